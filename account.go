@@ -0,0 +1,433 @@
+package auth
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/mail"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Error messages related to local accounts
+var (
+	ErrAccountExists       = errors.New("An account already exists for this email address")
+	ErrAccountNotFound     = errors.New("Account not found")
+	ErrAccountNotVerified  = errors.New("Email address has not been verified")
+	ErrInvalidAccountToken = errors.New("Invalid or expired link")
+	ErrInvalidEmail        = errors.New("Invalid email address")
+	ErrPasswordTooShort    = errors.New("Password is too short")
+)
+
+// Token purposes. The purpose is signed with the token's data, which
+// prevents a token issued for one purpose from being used for another.
+const (
+	verifyTokenPurpose = "verify"
+	resetTokenPurpose  = "reset"
+)
+
+// An Account is a local user account, identified by its email address.
+type Account struct {
+	Id           string
+	Email        string
+	Name         string
+	PasswordHash string
+	Verified     bool
+	Created      time.Time
+}
+
+// An AccountStore saves local accounts.
+type AccountStore interface {
+
+	// Create saves a new account. ErrAccountExists is returned if an
+	// account exists for the email address.
+	Create(a *Account) error
+
+	// Get returns the account with the specified email address, or
+	// ErrAccountNotFound.
+	Get(email string) (*Account, error)
+
+	// Update saves the changes to an existing account.
+	Update(a *Account) error
+}
+
+// MemoryAccountStore is an in-memory implementation of AccountStore, for
+// development and tests. Accounts are lost when the application restarts.
+type MemoryAccountStore struct {
+	sync.Mutex
+	accounts map[string]Account
+}
+
+// NewMemoryAccountStore allocates and returns a new MemoryAccountStore.
+func NewMemoryAccountStore() *MemoryAccountStore {
+	return &MemoryAccountStore{accounts: map[string]Account{}}
+}
+
+func (self *MemoryAccountStore) Create(a *Account) error {
+	self.Lock()
+	defer self.Unlock()
+
+	if _, ok := self.accounts[a.Email]; ok {
+		return ErrAccountExists
+	}
+	self.accounts[a.Email] = *a
+	return nil
+}
+
+func (self *MemoryAccountStore) Get(email string) (*Account, error) {
+	self.Lock()
+	defer self.Unlock()
+
+	a, ok := self.accounts[email]
+	if !ok {
+		return nil, ErrAccountNotFound
+	}
+	return &a, nil
+}
+
+func (self *MemoryAccountStore) Update(a *Account) error {
+	self.Lock()
+	defer self.Unlock()
+
+	if _, ok := self.accounts[a.Email]; !ok {
+		return ErrAccountNotFound
+	}
+	self.accounts[a.Email] = *a
+	return nil
+}
+
+// LocalAccounts manages the lifecycle of local accounts: registration,
+// email verification and password reset. Verification and reset links are
+// signed using the AuthConfig's codec, so no tokens are stored.
+//
+// LocalAccounts is a PasswordVerifier, so local accounts can login using a
+// PasswordProvider, and are returned as a User with the provider "local".
+type LocalAccounts struct {
+	Store  AccountStore
+	Mailer Mailer
+	Hasher *PasswordHasher
+
+	// Config signs the verification and reset links. If nil, the global
+	// Config is used.
+	Config *AuthConfig
+
+	// VerifyURL and ResetURL are the absolute urls of the email
+	// verification and password reset pages. The token is appended to the
+	// url in the "token" query parameter.
+	VerifyURL string
+	ResetURL  string
+
+	// VerifyExp and ResetExp are the amount of time the verification and
+	// reset links are valid.
+	VerifyExp time.Duration
+	ResetExp  time.Duration
+
+	// MinPasswordLength is the minimum length of a password.
+	MinPasswordLength int
+
+	dummyOnce sync.Once
+	dummy     string
+}
+
+// NewLocalAccounts allocates and returns a new LocalAccounts, sending email
+// verification and password reset links to the specified urls.
+func NewLocalAccounts(store AccountStore, mailer Mailer, verifyURL, resetURL string) *LocalAccounts {
+	return &LocalAccounts{
+		Store:             store,
+		Mailer:            mailer,
+		Hasher:            NewPasswordHasher(),
+		VerifyURL:         verifyURL,
+		ResetURL:          resetURL,
+		VerifyExp:         time.Hour * 24,
+		ResetExp:          time.Hour,
+		MinPasswordLength: 8,
+	}
+}
+
+// Register creates a new, unverified account, and sends an email
+// verification link to the email address.
+func (self *LocalAccounts) Register(email, name, password string) (*Account, error) {
+	email = normalizeEmail(email)
+	if !validEmail(email) {
+		return nil, ErrInvalidEmail
+	}
+	if len(password) < self.MinPasswordLength {
+		return nil, ErrPasswordTooShort
+	}
+
+	hash, err := self.Hasher.Hash(password)
+	if err != nil {
+		return nil, err
+	}
+	account := Account{
+		Id:           randomString(16),
+		Email:        email,
+		Name:         name,
+		PasswordHash: hash,
+		Created:      time.Now().UTC(),
+	}
+	if err := self.Store.Create(&account); err != nil {
+		return nil, err
+	}
+	return &account, self.SendVerification(&account)
+}
+
+// SendVerification sends an email verification link for the account.
+func (self *LocalAccounts) SendVerification(a *Account) error {
+	link, err := self.link(self.VerifyURL, verifyTokenPurpose, a.Email, self.VerifyExp)
+	if err != nil {
+		return err
+	}
+	return self.Mailer.Send(a.Email, "Verify your email address",
+		fmt.Sprintf("Follow this link to verify your email address:\n\n%s\n\nThe link expires in %s.", link, self.VerifyExp))
+}
+
+// VerifyEmail marks the account as verified, using the token from an email
+// verification link.
+func (self *LocalAccounts) VerifyEmail(token string) (*Account, error) {
+	email, err := self.readToken(verifyTokenPurpose, token)
+	if err != nil {
+		return nil, err
+	}
+	account, err := self.Store.Get(email)
+	if err != nil {
+		return nil, ErrInvalidAccountToken
+	}
+	if account.Verified {
+		return account, nil
+	}
+	account.Verified = true
+	return account, self.Store.Update(account)
+}
+
+// RequestReset sends a password reset link to the email address. No error
+// is returned if the account does not exist, so that the response does not
+// reveal which email addresses have an account.
+func (self *LocalAccounts) RequestReset(email string) error {
+	account, err := self.Store.Get(normalizeEmail(email))
+	if err == ErrAccountNotFound {
+		return nil
+	} else if err != nil {
+		return err
+	}
+
+	link, err := self.link(self.ResetURL, resetTokenPurpose, account.Email+"|"+passwordFingerprint(account), self.ResetExp)
+	if err != nil {
+		return err
+	}
+	return self.Mailer.Send(account.Email, "Reset your password",
+		fmt.Sprintf("Follow this link to choose a new password:\n\n%s\n\nThe link expires in %s. If you did not ask to reset your password, you can ignore this email.", link, self.ResetExp))
+}
+
+// ResetPassword changes the account's password, using the token from a
+// password reset link. The token includes a fingerprint of the previous
+// password hash, so a link can only be used once.
+func (self *LocalAccounts) ResetPassword(token, password string) (*Account, error) {
+	data, err := self.readToken(resetTokenPurpose, token)
+	if err != nil {
+		return nil, err
+	}
+	email, fingerprint, _ := strings.Cut(data, "|")
+	account, err := self.Store.Get(email)
+	if err != nil || passwordFingerprint(account) != fingerprint {
+		return nil, ErrInvalidAccountToken
+	}
+	if len(password) < self.MinPasswordLength {
+		return nil, ErrPasswordTooShort
+	}
+
+	hash, err := self.Hasher.Hash(password)
+	if err != nil {
+		return nil, err
+	}
+	account.PasswordHash = hash
+
+	// the user received the link by email, so the address is verified
+	account.Verified = true
+	return account, self.Store.Update(account)
+}
+
+// Verify returns the User for the account, if the password is valid and the
+// email address has been verified. The password is re-hashed if it was
+// hashed using an outdated algorithm or parameters.
+func (self *LocalAccounts) Verify(username, password string) (User, error) {
+	account, err := self.Store.Get(normalizeEmail(username))
+	if err == ErrAccountNotFound {
+		// compare against a dummy hash, so an unknown user takes as long
+		// to reject as an invalid password
+		self.Hasher.Compare(self.dummyHash(), password)
+		return nil, ErrInvalidCredentials
+	} else if err != nil {
+		return nil, err
+	}
+
+	ok, rehash := self.Hasher.Compare(account.PasswordHash, password)
+	if !ok {
+		return nil, ErrInvalidCredentials
+	}
+	if !account.Verified {
+		return nil, ErrAccountNotVerified
+	}
+	if rehash {
+		if hash, err := self.Hasher.Hash(password); err == nil {
+			account.PasswordHash = hash
+			self.Store.Update(account)
+		}
+	}
+
+	u := user{
+		id:       account.Id,
+		provider: "local",
+		name:     account.Name,
+		email:    account.Email,
+	}
+	return &u, nil
+}
+
+// SignupHandler registers an account using the posted "email", "name" and
+// "password" form values.
+func (self *LocalAccounts) SignupHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+		return
+	}
+	email := r.PostFormValue("email")
+	_, err := self.Register(email, r.PostFormValue("name"), r.PostFormValue("password"))
+	if err == ErrAccountExists {
+		// the response is the same whether or not the account exists,
+		// so it does not reveal which email addresses have an account
+		err = self.sendAccountExists(email)
+	}
+	switch err {
+	case nil:
+		w.WriteHeader(http.StatusCreated)
+		w.Write([]byte("Check your email to verify your email address\n"))
+	case ErrInvalidEmail, ErrPasswordTooShort:
+		http.Error(w, err.Error(), http.StatusBadRequest)
+	default:
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
+
+// sendAccountExists tells the owner of an existing account that someone
+// tried to sign up with their email address.
+func (self *LocalAccounts) sendAccountExists(email string) error {
+	account, err := self.Store.Get(normalizeEmail(email))
+	if err != nil {
+		return err
+	}
+	return self.Mailer.Send(account.Email, "Your account",
+		fmt.Sprintf("Someone tried to sign up with this email address, which already has an account. If this was you, you can log in, or reset your password here:\n\n%s\n\nOtherwise, you can ignore this email.", self.ResetURL))
+}
+
+// VerifyHandler verifies an email address, using the "token" query
+// parameter of the verification link, and redirects the user to the login
+// page.
+func (self *LocalAccounts) VerifyHandler(w http.ResponseWriter, r *http.Request) {
+	if _, err := self.VerifyEmail(r.URL.Query().Get("token")); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	http.Redirect(w, r, self.config().LoginRedirect, http.StatusSeeOther)
+}
+
+// ResetRequestHandler sends a password reset link to the posted "email"
+// form value.
+func (self *LocalAccounts) ResetRequestHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+		return
+	}
+	if err := self.RequestReset(r.PostFormValue("email")); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Write([]byte("If an account exists, a password reset link has been sent to your email address\n"))
+}
+
+// ResetHandler changes the password using the posted "token" and "password"
+// form values, and redirects the user to the login page.
+func (self *LocalAccounts) ResetHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+		return
+	}
+	if _, err := self.ResetPassword(r.PostFormValue("token"), r.PostFormValue("password")); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	http.Redirect(w, r, self.config().LoginRedirect, http.StatusSeeOther)
+}
+
+// config returns the AuthConfig used to sign tokens.
+func (self *LocalAccounts) config() *AuthConfig {
+	if self.Config != nil {
+		return self.Config
+	}
+	return Config
+}
+
+// link returns the url with a signed, expiring token appended. The token's
+// data is prefixed with its purpose.
+func (self *LocalAccounts) link(base, purpose, data string, exp time.Duration) (string, error) {
+	token, err := self.config().codec().Encode(purpose, purpose+"|"+data, time.Now().Add(exp))
+	if err != nil {
+		return "", err
+	}
+	sep := "?"
+	if strings.Contains(base, "?") {
+		sep = "&"
+	}
+	return base + sep + "token=" + url.QueryEscape(token), nil
+}
+
+// readToken verifies the signature, expiration and purpose of a token, and
+// returns its data.
+func (self *LocalAccounts) readToken(purpose, token string) (string, error) {
+	data, expires, err := self.config().codec().Decode(purpose, token)
+	if err != nil || time.Now().After(expires) {
+		return "", ErrInvalidAccountToken
+	}
+	prefix, data, ok := strings.Cut(data, "|")
+	if !ok || prefix != purpose {
+		return "", ErrInvalidAccountToken
+	}
+	return data, nil
+}
+
+// dummyHash returns a hash of a random password, using the Hasher's
+// algorithm, so that comparing against it takes as long as comparing
+// against a real account's hash.
+func (self *LocalAccounts) dummyHash() string {
+	self.dummyOnce.Do(func() {
+		self.dummy, _ = self.Hasher.Hash(randomString(16))
+	})
+	return self.dummy
+}
+
+// passwordFingerprint returns a hash of the account's password hash, which
+// changes whenever the password is changed.
+func passwordFingerprint(a *Account) string {
+	sum := sha256.Sum256([]byte(a.PasswordHash))
+	return hex.EncodeToString(sum[:16])
+}
+
+// normalizeEmail returns the email address in lower case, without
+// surrounding whitespace.
+func normalizeEmail(email string) string {
+	return strings.ToLower(strings.TrimSpace(email))
+}
+
+// validEmail returns true if the email is a single address, without a
+// display name, that can be safely used in a message header.
+func validEmail(email string) bool {
+	if strings.ContainsAny(email, "\r\n") {
+		return false
+	}
+	addr, err := mail.ParseAddress(email)
+	return err == nil && addr.Address == email
+}
@@ -0,0 +1,128 @@
+package auth
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"fmt"
+	"strings"
+
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// Password hashing algorithms supported for local accounts.
+const (
+	HashArgon2id = "argon2id"
+	HashBcrypt   = "bcrypt"
+)
+
+// Argon2idParams are the argon2id cost parameters used to hash passwords.
+// See https://tools.ietf.org/html/rfc9106#section-4
+type Argon2idParams struct {
+	Memory      uint32 // Memory in KiB
+	Iterations  uint32
+	Parallelism uint8
+	SaltLength  uint32
+	KeyLength   uint32
+}
+
+// DefaultArgon2idParams are the second recommended option of RFC 9106, for
+// environments where memory is constrained.
+var DefaultArgon2idParams = Argon2idParams{
+	Memory:      64 * 1024,
+	Iterations:  3,
+	Parallelism: 4,
+	SaltLength:  16,
+	KeyLength:   32,
+}
+
+// PasswordHasher hashes the passwords of local accounts. Passwords hashed
+// with a different algorithm, or weaker parameters, are re-hashed when the
+// user logs in.
+type PasswordHasher struct {
+	// Algorithm is HashArgon2id or HashBcrypt.
+	Algorithm string
+
+	Argon2id   Argon2idParams
+	BcryptCost int
+}
+
+// NewPasswordHasher allocates and returns a new PasswordHasher, using the
+// argon2id algorithm.
+func NewPasswordHasher() *PasswordHasher {
+	return &PasswordHasher{
+		Algorithm:  HashArgon2id,
+		Argon2id:   DefaultArgon2idParams,
+		BcryptCost: bcrypt.DefaultCost,
+	}
+}
+
+// Hash returns the encoded hash of the password.
+func (self *PasswordHasher) Hash(password string) (string, error) {
+	if self.Algorithm == HashBcrypt {
+		hash, err := bcrypt.GenerateFromPassword([]byte(password), self.BcryptCost)
+		return string(hash), err
+	}
+
+	p := self.Argon2id
+	salt := make([]byte, p.SaltLength)
+	if _, err := rand.Read(salt); err != nil {
+		return "", err
+	}
+	key := argon2.IDKey([]byte(password), salt, p.Iterations, p.Memory, p.Parallelism, p.KeyLength)
+	return fmt.Sprintf("$argon2id$v=%d$m=%d,t=%d,p=%d$%s$%s", argon2.Version, p.Memory, p.Iterations, p.Parallelism,
+		base64.RawStdEncoding.EncodeToString(salt), base64.RawStdEncoding.EncodeToString(key)), nil
+}
+
+// Compare returns true if the password matches the encoded hash, and
+// whether the password should be re-hashed with the current algorithm and
+// parameters.
+func (self *PasswordHasher) Compare(hash, password string) (ok, rehash bool) {
+	if strings.HasPrefix(hash, "$argon2id$") {
+		p, salt, key, err := decodeArgon2id(hash)
+		if err != nil {
+			return false, false
+		}
+		other := argon2.IDKey([]byte(password), salt, p.Iterations, p.Memory, p.Parallelism, uint32(len(key)))
+		if subtle.ConstantTimeCompare(key, other) != 1 {
+			return false, false
+		}
+		return true, self.Algorithm != HashArgon2id || p != self.Argon2id
+	}
+
+	if bcrypt.CompareHashAndPassword([]byte(hash), []byte(password)) != nil {
+		return false, false
+	}
+	cost, _ := bcrypt.Cost([]byte(hash))
+	return true, self.Algorithm != HashBcrypt || cost < self.BcryptCost
+}
+
+// decodeArgon2id parses the parameters, salt and key of an encoded argon2id
+// hash, in the format "$argon2id$v=19$m=65536,t=3,p=4$salt$key".
+func decodeArgon2id(hash string) (Argon2idParams, []byte, []byte, error) {
+	p := Argon2idParams{}
+	parts := strings.Split(hash, "$")
+	if len(parts) != 6 {
+		return p, nil, nil, fmt.Errorf("Invalid argon2id hash")
+	}
+
+	var version int
+	if _, err := fmt.Sscanf(parts[2], "v=%d", &version); err != nil || version != argon2.Version {
+		return p, nil, nil, fmt.Errorf("Unsupported argon2id version")
+	}
+	if _, err := fmt.Sscanf(parts[3], "m=%d,t=%d,p=%d", &p.Memory, &p.Iterations, &p.Parallelism); err != nil {
+		return p, nil, nil, err
+	}
+	salt, err := base64.RawStdEncoding.DecodeString(parts[4])
+	if err != nil {
+		return p, nil, nil, err
+	}
+	key, err := base64.RawStdEncoding.DecodeString(parts[5])
+	if err != nil {
+		return p, nil, nil, err
+	}
+	p.SaltLength = uint32(len(salt))
+	p.KeyLength = uint32(len(key))
+	return p, salt, key, nil
+}
@@ -0,0 +1,135 @@
+package auth
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// Test the ability to register a local account, verify the email address,
+// and reset the password using a single-use link.
+func TestLocalAccounts(t *testing.T) {
+	var message string
+	mailer := MailerFunc(func(to, subject, body string) error {
+		message = body
+		return nil
+	})
+	accounts := NewLocalAccounts(NewMemoryAccountStore(), mailer, "https://example.com/verify", "https://example.com/reset")
+	accounts.Hasher.Argon2id.Memory = 1024
+
+	// token returns the token of the link in the last message
+	token := func() string {
+		link := regexp.MustCompile(`https://\S+`).FindString(message)
+		u, _ := url.Parse(link)
+		return u.Query().Get("token")
+	}
+
+	if _, err := accounts.Register("Alice@Example.com", "Alice", "secret123"); err != nil {
+		t.Fatalf("Expected account registered, got Error %s", err.Error())
+	}
+	if _, err := accounts.Register("alice@example.com", "Alice", "secret123"); err != ErrAccountExists {
+		t.Errorf("Expected ErrAccountExists, got %v", err)
+	}
+	if _, err := accounts.Verify("alice@example.com", "secret123"); err != ErrAccountNotVerified {
+		t.Errorf("Expected ErrAccountNotVerified, got %v", err)
+	}
+
+	verify := token()
+	if _, err := accounts.ResetPassword(verify, "changed123"); err != ErrInvalidAccountToken {
+		t.Errorf("Expected verification token rejected as a reset token, got %v", err)
+	}
+	if _, err := accounts.VerifyEmail(verify); err != nil {
+		t.Fatalf("Expected email verified, got Error %s", err.Error())
+	}
+	u, err := accounts.Verify("alice@example.com", "secret123")
+	if err != nil || u.Provider() != "local" || u.Email() != "alice@example.com" {
+		t.Errorf("Expected local User alice@example.com, got %v", err)
+	}
+
+	// the purpose is signed with the data, so a token for another purpose
+	// is rejected even if its data has the same shape
+	account, _ := accounts.Store.Get("alice@example.com")
+	forged, _ := accounts.link("https://example.com/reset", verifyTokenPurpose, account.Email+"|"+passwordFingerprint(account), time.Hour)
+	message = forged
+	if _, err := accounts.ResetPassword(token(), "changed123"); err != ErrInvalidAccountToken {
+		t.Errorf("Expected verification token rejected as a reset token, got %v", err)
+	}
+
+	accounts.RequestReset("alice@example.com")
+	reset := token()
+	if _, err := accounts.VerifyEmail(reset); err != ErrInvalidAccountToken {
+		t.Errorf("Expected reset token rejected as a verification token, got %v", err)
+	}
+	if _, err := accounts.ResetPassword(reset, "changed123"); err != nil {
+		t.Fatalf("Expected password reset, got Error %s", err.Error())
+	}
+	if _, err := accounts.ResetPassword(reset, "again1234"); err != ErrInvalidAccountToken {
+		t.Errorf("Expected reset token to be single use, got %v", err)
+	}
+	if _, err := accounts.Verify("alice@example.com", "changed123"); err != nil {
+		t.Errorf("Expected login with the new password, got %v", err)
+	}
+	if _, err := accounts.Verify("bob@example.com", "changed123"); err != ErrInvalidCredentials {
+		t.Errorf("Expected ErrInvalidCredentials for an unknown user, got %v", err)
+	}
+}
+
+// Test the ability to upgrade a bcrypt password hash to argon2id when the
+// user logs in.
+func TestPasswordRehash(t *testing.T) {
+	store := NewMemoryAccountStore()
+	accounts := NewLocalAccounts(store, NewLogMailer(), "", "")
+	accounts.Hasher.Argon2id.Memory = 1024
+
+	hash, _ := bcrypt.GenerateFromPassword([]byte("secret123"), bcrypt.MinCost)
+	store.Create(&Account{Id: "1", Email: "alice@example.com", PasswordHash: string(hash), Verified: true})
+
+	if _, err := accounts.Verify("alice@example.com", "secret123"); err != nil {
+		t.Fatalf("Expected bcrypt password accepted, got Error %s", err.Error())
+	}
+	account, _ := store.Get("alice@example.com")
+	if _, rehash := accounts.Hasher.Compare(account.PasswordHash, "secret123"); rehash || account.PasswordHash[:10] != "$argon2id$" {
+		t.Errorf("Expected password re-hashed using argon2id, got %s", account.PasswordHash)
+	}
+}
+
+// Test the ability to reject email addresses that would inject headers, and
+// to sign up without revealing which email addresses have an account.
+func TestSignupHandler(t *testing.T) {
+	var to []string
+	mailer := MailerFunc(func(addr, subject, body string) error {
+		to = append(to, addr)
+		return nil
+	})
+	accounts := NewLocalAccounts(NewMemoryAccountStore(), mailer, "https://example.com/verify", "https://example.com/reset")
+	accounts.Hasher.Argon2id.Memory = 1024
+
+	for _, email := range []string{"alice@example.com\r\nBcc: eve@example.com", "Alice <alice@example.com>", "alice"} {
+		if _, err := accounts.Register(email, "Alice", "secret123"); err != ErrInvalidEmail {
+			t.Errorf("Expected ErrInvalidEmail for %q, got %v", email, err)
+		}
+	}
+
+	signup := func() *httptest.ResponseRecorder {
+		form := url.Values{"email": {"alice@example.com"}, "name": {"Alice"}, "password": {"secret123"}}
+		r, _ := http.NewRequest("POST", "/signup", strings.NewReader(form.Encode()))
+		r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		w := httptest.NewRecorder()
+		accounts.SignupHandler(w, r)
+		return w
+	}
+	first, second := signup(), signup()
+	if first.Code != second.Code || first.Body.String() != second.Body.String() {
+		t.Errorf("Expected the same response for an existing account, got %d %q and %d %q",
+			first.Code, first.Body.String(), second.Code, second.Body.String())
+	}
+	if len(to) != 2 || to[1] != "alice@example.com" {
+		t.Errorf("Expected the existing account's owner emailed, got %v", to)
+	}
+}
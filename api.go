@@ -0,0 +1,107 @@
+package auth
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// ErrAuthRequired is passed to the Unauthorized func when the request has
+// no user session.
+var ErrAuthRequired = errors.New("Authentication required")
+
+// DefaultUnauthorized will return an http Unauthorized code, with a
+// WWW-Authenticate challenge and a JSON error body, indicating the API
+// request requires authentication.
+var DefaultUnauthorized = func(w http.ResponseWriter, r *http.Request, err error) {
+	config := authenticatorFor(r).config
+	w.Header().Set("WWW-Authenticate", fmt.Sprintf(`Cookie realm=%q, form-action=%q, cookie-name=%q`,
+		config.realm(r), config.LoginRedirect, config.cookieName(config.CookieName)))
+	writeJSONError(w, http.StatusUnauthorized, "unauthorized", err.Error())
+}
+
+// SecureAPI will attempt to verify a user session exists prior to serving
+// the request with the http.Handler. If no valid sessions exists, the
+// Config.Unauthorized func is invoked, instead of redirecting the user to
+// the login page.
+func SecureAPI(handler http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		defaultAuthenticator().SecureAPI(handler).ServeHTTP(w, r)
+	})
+}
+
+// SecureAPI will attempt to verify a user session exists prior to serving
+// the request with the http.Handler, using the Authenticator's config.
+func (self *Authenticator) SecureAPI(handler http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		user, err := self.config.getSessionUser(w, r)
+		if err != nil || user.Id() == "" {
+			self.unauthorized(w, r, err)
+			return
+		}
+		handler.ServeHTTP(w, self.withUser(r, user))
+	})
+}
+
+// unauthenticated responds to a request that has no user session. API
+// requests receive a 401 response, and all other requests are redirected to
+// the login page.
+func (self *Authenticator) unauthenticated(w http.ResponseWriter, r *http.Request, err error) {
+	if isAPIRequest(r) {
+		self.unauthorized(w, r, err)
+		return
+	}
+	http.Redirect(w, r, self.config.loginURL(r), http.StatusSeeOther)
+}
+
+// unauthorized invokes the Config.Unauthorized func, or DefaultUnauthorized.
+func (self *Authenticator) unauthorized(w http.ResponseWriter, r *http.Request, err error) {
+	if err == nil || err == http.ErrNoCookie {
+		err = ErrAuthRequired
+	}
+
+	r = self.withAuthenticator(r)
+	if self.config.Unauthorized != nil {
+		self.config.Unauthorized(w, r, err)
+	} else {
+		DefaultUnauthorized(w, r, err)
+	}
+}
+
+// isAPIRequest returns true if the request was made by a script or an API
+// client, rather than by a browser navigating to a page.
+func isAPIRequest(r *http.Request) bool {
+	if r.Header.Get("X-Requested-With") == "XMLHttpRequest" {
+		return true
+	}
+
+	// sent by browsers for fetch and XMLHttpRequest calls
+	if mode := r.Header.Get("Sec-Fetch-Mode"); len(mode) > 0 {
+		return mode != "navigate"
+	}
+
+	accept := r.Header.Get("Accept")
+	return strings.Contains(accept, "application/json") && !strings.Contains(accept, "text/html")
+}
+
+// realm returns the Realm, defaulting to the host of the request.
+func (self *AuthConfig) realm(r *http.Request) string {
+	if len(self.Realm) > 0 {
+		return self.Realm
+	}
+	return r.Host
+}
+
+// writeJSONError writes an error response, with a JSON body in the format
+// of an OAuth 2.0 error response.
+func writeJSONError(w http.ResponseWriter, status int, code, description string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(map[string]string{
+		"error":             code,
+		"error_description": description,
+	})
+}
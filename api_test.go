@@ -0,0 +1,41 @@
+package auth
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// Test the ability to respond to unauthenticated API requests with a 401,
+// while still redirecting browsers to the login page.
+func TestUnauthorizedAPI(t *testing.T) {
+	handler := SecureFunc(func(w http.ResponseWriter, r *http.Request) {})
+
+	r, _ := http.NewRequest("GET", "/api/items", nil)
+	r.Header.Set("Accept", "application/json")
+	w := httptest.NewRecorder()
+	handler(w, r)
+
+	if w.Code != http.StatusUnauthorized {
+		t.Errorf("Expected status 401, got %d", w.Code)
+	}
+	if challenge := w.Header().Get("WWW-Authenticate"); !strings.HasPrefix(challenge, "Cookie realm=") {
+		t.Errorf("Expected WWW-Authenticate challenge, got %q", challenge)
+	}
+	body := map[string]string{}
+	json.NewDecoder(w.Body).Decode(&body)
+	if body["error"] != "unauthorized" {
+		t.Errorf("Expected JSON error unauthorized, got %v", body)
+	}
+
+	r, _ = http.NewRequest("GET", "/items", nil)
+	r.Header.Set("Accept", "text/html,application/xhtml+xml")
+	r.Header.Set("Sec-Fetch-Mode", "navigate")
+	w = httptest.NewRecorder()
+	handler(w, r)
+	if w.Code != http.StatusSeeOther {
+		t.Errorf("Expected browser redirected with status 303, got %d", w.Code)
+	}
+}
@@ -2,7 +2,6 @@ package auth
 
 import (
 	"net/http"
-	"net/url"
 	"time"
 )
 
@@ -13,12 +12,17 @@ type AuthHandler struct {
 	provider AuthProvider
 
 	// Success specifies a function to execute upon successful authentication.
-	// If Success is nil, the DefaultSuccess func is used.
+	// If Success is nil, the DefaultSuccess func is used. The url the User
+	// was trying to reach before logging in is available from SuccessURL.
 	Success func(w http.ResponseWriter, r *http.Request, u User, t Token)
 
 	// Failure specifies a function to execute upon failing authentication.
 	// If Failure is nil, the DefaultFailure func is used.
 	Failure func(w http.ResponseWriter, r *http.Request, err error)
+
+	// auth is the Authenticator that created the handler. If auth is nil,
+	// the default Authenticator is used.
+	auth *Authenticator
 }
 
 // New allocates and returns a new AuthHandler, using the specified
@@ -27,14 +31,23 @@ func New(p AuthProvider) *AuthHandler {
 	return &AuthHandler{ provider : p }
 }
 
+// authenticator returns the Authenticator that created the handler, or the
+// default Authenticator.
+func (self *AuthHandler) authenticator() *Authenticator {
+	if self.auth != nil {
+		return self.auth
+	}
+	return defaultAuthenticator()
+}
+
 // Google allocates and returns a new AuthHandler, using the GoogleProvider.
-func Google(client, secret, redirect string) *AuthHandler {
-	return New(NewGoogleProvider(client, secret, redirect))
+func Google(client, secret, redirect string, opts ...GoogleOption) *AuthHandler {
+	return New(NewGoogleProvider(client, secret, redirect, opts...))
 }
 
 // Github allocates and returns a new AuthHandler, using the GithubProvider.
-func Github(client, secret, scope string) *AuthHandler {
-	return New(NewGithubProvider(client, secret, scope))
+func Github(client, secret, scope string, opts ...GithubOption) *AuthHandler {
+	return New(NewGithubProvider(client, secret, scope, opts...))
 }
 
 // OpenId allocates and returns a new AuthHandler, using the OpenIdProvider.
@@ -42,6 +55,11 @@ func OpenId(url string) *AuthHandler {
 	return New(NewOpenIdProvider(url))
 }
 
+// OIDC allocates and returns a new AuthHandler, using the OIDCProvider.
+func OIDC(issuer, client, secret, redirect string) *AuthHandler {
+	return New(NewOIDCProvider(issuer, client, secret, redirect))
+}
+
 // Bitbucket allocates and returns a new AuthHandler, using the BitbucketProvider.
 func Bitbucket(key, secret, callback string) *AuthHandler {
 	return New(NewBitbucketProvider(key, secret, callback))
@@ -52,17 +70,31 @@ func Twitter(key, secret, callback string) *AuthHandler {
 	return New(NewTwitterProvider(key, secret, callback))
 }
 
+// Password allocates and returns a new AuthHandler, using the
+// PasswordProvider. The login form is displayed again if the login fails.
+func Password(verifier PasswordVerifier) *AuthHandler {
+	p := NewPasswordProvider(verifier)
+	return &AuthHandler{ provider : p, Failure : p.Failure }
+}
+
 // ServeHTTP handles the authentication request and manages the
 // authentication flow.
 func (self *AuthHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 
-	// Redirect the user, if required
+	// Make the Authenticator's config available to the provider
+	if self.auth != nil {
+		r = self.auth.withAuthenticator(r)
+	}
+
+	// Redirect the user, if required. The provider saves the url to send
+	// the user to after authentication with the state of the login flow
 	if self.provider.RedirectRequired(r) == true {
 		self.provider.Redirect(w, r)
 		return
 	}
 
-	// Get the authenticated user Id
+	// Get the authenticated user Id, and the saved url
+	r = withNext(r)
 	u, t, err := self.provider.GetAuthenticatedUser(w, r)
 	if err != nil {
 		// If there was a problem, invoke failure
@@ -82,16 +114,26 @@ func (self *AuthHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
-// DefaultSuccess will redirect a User, using an http.Redirect, to the
+// DefaultSuccess will redirect a User, using an http.Redirect, to the url the
+// User was trying to reach before logging in, or to the
 // Config.LoginSuccessRedirect url upon successful authentication.
 var DefaultSuccess = func(w http.ResponseWriter, r *http.Request, u User, t Token) {
-	SetUserCookie(w, r, u)
-	http.Redirect(w, r, Config.LoginSuccessRedirect, http.StatusSeeOther)
+	a := authenticatorFor(r)
+	if err := a.SetUserCookie(w, r, u); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	http.Redirect(w, r, SuccessURL(r), http.StatusSeeOther)
 }
 
 // DefaultFailure will return an http Forbidden code indicating a failed
-// authentication.
+// authentication, or an http Unauthorized code if the provider challenged
+// the client to authenticate again (ie Basic authentication).
 var DefaultFailure = func(w http.ResponseWriter, r *http.Request, err error) {
+	if len(w.Header().Get("WWW-Authenticate")) > 0 {
+		http.Error(w, err.Error(), http.StatusUnauthorized)
+		return
+	}
 	http.Error(w, err.Error(), http.StatusForbidden)
 }
 
@@ -124,19 +166,75 @@ type AuthConfig struct {
 	CookieHttpOnly        bool
 	LoginRedirect         string
 	LoginSuccessRedirect  string
-	
+
+	// RedirectHosts lists the hosts, other than the host of the request,
+	// that a user may be returned to after login.
+	RedirectHosts         []string
+
+	// Realm is included in the WWW-Authenticate challenge. If empty, the
+	// host of the request is used.
+	Realm                 string
+
+	// Unauthorized specifies a function to execute when an API request
+	// has no user session, instead of redirecting to the LoginRedirect.
+	// If Unauthorized is nil, the DefaultUnauthorized func is used.
+	Unauthorized          func(w http.ResponseWriter, r *http.Request, err error)
+
+	// StateExp is the amount of time a User has to complete an OAuth2
+	// login flow before the state parameter expires.
+	StateExp              time.Duration
+
+	// CookieCodec encodes and decodes the secure cookies. If CookieCodec
+	// is nil, cookies are signed using the CookieSecret, but not encrypted.
+	// Use a KeyringCodec to rotate the secret without logging users out.
+	CookieCodec           Codec
+
+	// CookieReissue re-issues session cookies that were encoded with a
+	// previous key of a RotatingCodec, using the current key.
+	CookieReissue         bool
+
+	// SessionStore saves login sessions server-side. If SessionStore is
+	// set, the session cookie holds only a random session id, otherwise
+	// the User data is stored in the cookie.
+	SessionStore          SessionStore
+
+	// SessionIdleTimeout expires a session that has not been used for the
+	// specified duration. Active sessions are extended automatically. If
+	// zero, sessions expire only after the SessionMaxLifetime.
+	SessionIdleTimeout    time.Duration
+
+	// SessionMaxLifetime is the absolute timeout of a session, measured
+	// from the time the user logged in. Sessions are never extended past
+	// this limit. If zero, the CookieExp is used.
+	SessionMaxLifetime    time.Duration
+
+	// CookiePolicy holds the SameSite, prefix, Domain, Path and Partitioned
+	// attributes of every cookie written by this package.
+	CookiePolicy          CookiePolicy
+
+	// RoleResolver returns the roles of a User, for SecureRoles and the
+	// HasRole policy. If nil, the roles are read from the session data.
+	RoleResolver          RoleResolver
 }
 
 // Config is the default implementation of Config, and is used by
 // DetaultAuthCallback, Secure, and SecureFunc.
-var Config = &AuthConfig{
-	CookieName:            "_sess",
-	CookieExp:             time.Hour * 24 * 14,
-	CookieMaxAge:          0,
-	CookieSecure:          true,
-	CookieHttpOnly:        true,
-	LoginRedirect:         "/auth/login",
-	LoginSuccessRedirect:  "/",
+var Config = NewConfig()
+
+// NewConfig allocates and returns a new AuthConfig with the default values,
+// for use with NewAuthenticator.
+func NewConfig() *AuthConfig {
+	return &AuthConfig{
+		CookieName:            "_sess",
+		CookieExp:             time.Hour * 24 * 14,
+		CookieMaxAge:          0,
+		CookieSecure:          true,
+		CookieHttpOnly:        true,
+		LoginRedirect:         "/auth/login",
+		LoginSuccessRedirect:  "/",
+		StateExp:              time.Minute * 5,
+		CookiePolicy:          CookiePolicy{ SameSite: http.SameSiteLaxMode },
+	}
 }
 
 // Passes back the OAuth Token. This will likely be the oauth2.Token or the
@@ -166,6 +264,7 @@ type user struct {
 	org      string
 	link     string
 	picture  string
+	data     map[string]string
 }
 
 func (u *user) Id() string       { return u.id }
@@ -176,26 +275,24 @@ func (u *user) Org() string      { return u.org }
 func (u *user) Link() string     { return u.link }
 func (u *user) Picture() string  { return u.picture }
 func (u *user) Avatar() string   { return u.picture }
+func (u *user) Data() map[string]string { return u.data }
 
 // SecureFunc will attempt to verify a user session exists prior to executing
 // the http.HandlerFunc. If no valid sessions exists, the user will be
-// redirected to the Config.LoginRedirect Url.
+// redirected to the Config.LoginRedirect Url, or an API request will receive
+// a 401 response from the Config.Unauthorized func.
 func SecureFunc(handler http.HandlerFunc) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
-		user, err := GetUserCookie(r)
-
-		//if no active user session then authorize user
-		if err != nil || user.Id() == "" {
-			http.Redirect(w, r, Config.LoginRedirect, http.StatusSeeOther)
-			return
-		}
-
-		//else, add the user to the URL and continue
-		r.URL.User = url.User(user.Id())
-		handler(w, r)
+		defaultAuthenticator().SecureFunc(handler)(w, r)
 	}
 }
 
+// SecureFunc will attempt to verify a user session exists prior to executing
+// the http.HandlerFunc, using the Authenticator's config.
+func (self *Authenticator) SecureFunc(handler http.HandlerFunc) http.HandlerFunc {
+	return self.Middleware(handler).ServeHTTP
+}
+
 // SecureHandlerFunc type is an adapter that extends the standard
 // http.HandlerFunc to include the authenticated User details.
 type SecureHandlerFunc func(w http.ResponseWriter, r *http.Request, u User)
@@ -205,16 +302,25 @@ type SecureHandlerFunc func(w http.ResponseWriter, r *http.Request, u User)
 // the user will be redirected to a login URL.
 func SecureUser(handler SecureHandlerFunc) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
-		user, err := GetUserCookie(r)
+		defaultAuthenticator().SecureUser(handler)(w, r)
+	}
+}
+
+// SecureUser will attempt to verify a user session exists prior to
+// executing the auth.SecureHandlerFunc function, using the Authenticator's
+// config.
+func (self *Authenticator) SecureUser(handler SecureHandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		user, err := self.config.getSessionUser(w, r)
 
 		//if no active user session then authorize user
 		if err != nil || user.Id() == "" {
-			http.Redirect(w, r, Config.LoginRedirect, http.StatusSeeOther)
+			self.unauthenticated(w, r, err)
 			return
 		}
 
 		//else, invoke the handler and provide the suer
-		handler(w, r, user)
+		handler(w, self.withUser(r, user), user)
 	}
 }
 
@@ -227,15 +333,24 @@ func SecureUser(handler SecureHandlerFunc) http.HandlerFunc {
 // additional details for authenticated users.
 func SecureGuest(handler SecureHandlerFunc) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
-		user, err := GetUserCookie(r)
+		defaultAuthenticator().SecureGuest(handler)(w, r)
+	}
+}
+
+// SecureGuest will attempt to retireve authenticated User details from
+// the current session, using the Authenticator's config. Guests are
+// allowed to proceed with nil User details.
+func (self *Authenticator) SecureGuest(handler SecureHandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		user, err := self.config.getSessionUser(w, r)
 
 		//if no active user session then authorize user
 		if err != nil || user.Id() == "" {
-			handler(w, r, nil)
+			handler(w, self.withUser(r, nil), nil)
 			return
 		}
 
 		//else, invoke the handler and provide the suer
-		handler(w, r, user)
+		handler(w, self.withUser(r, user), user)
 	}
 }
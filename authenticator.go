@@ -0,0 +1,60 @@
+package auth
+
+import (
+	"context"
+	"net/http"
+)
+
+// An Authenticator authenticates users and manages their sessions using its
+// own AuthConfig, so that applications with different cookie names, secrets
+// or redirects can run in the same process. The package-level functions use
+// a default Authenticator, backed by the global Config.
+type Authenticator struct {
+	config *AuthConfig
+}
+
+// NewAuthenticator allocates and returns a new Authenticator, using the
+// specified config. Use NewConfig to start from the default values. The
+// config should not be shared with other Authenticators, or modified while
+// requests are being served.
+func NewAuthenticator(config *AuthConfig) *Authenticator {
+	return &Authenticator{config}
+}
+
+// Config returns the Authenticator's config.
+func (self *Authenticator) Config() *AuthConfig {
+	return self.config
+}
+
+// New allocates and returns a new AuthHandler, using the specified
+// AuthProvider. The User's session is created using the Authenticator's
+// config.
+func (self *Authenticator) New(p AuthProvider) *AuthHandler {
+	return &AuthHandler{provider: p, auth: self}
+}
+
+// authenticatorKey is the context key of the Authenticator serving a request.
+type authenticatorKey struct{}
+
+// withAuthenticator returns a copy of the request, with the Authenticator
+// added to its context. Providers use the Authenticator's config to write
+// the state cookies, and DefaultSuccess uses it to create the session.
+func (self *Authenticator) withAuthenticator(r *http.Request) *http.Request {
+	return r.WithContext(context.WithValue(r.Context(), authenticatorKey{}, self))
+}
+
+// defaultAuthenticator returns the Authenticator backed by the global Config.
+// The package-level functions resolve it on each request, so that the global
+// Config may be replaced after the handlers are wrapped.
+func defaultAuthenticator() *Authenticator {
+	return &Authenticator{Config}
+}
+
+// authenticatorFor returns the Authenticator serving the request, or the
+// default Authenticator.
+func authenticatorFor(r *http.Request) *Authenticator {
+	if a, ok := r.Context().Value(authenticatorKey{}).(*Authenticator); ok {
+		return a
+	}
+	return defaultAuthenticator()
+}
@@ -0,0 +1,98 @@
+package auth
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// Test the ability to run two Authenticators with different configs, without
+// one accepting the other's sessions.
+func TestAuthenticator(t *testing.T) {
+	admin := NewConfig()
+	admin.CookieName = "_admin"
+	admin.CookieSecret = []byte("admin-secret")
+	admin.LoginRedirect = "/admin/login"
+
+	other := NewConfig()
+	other.CookieSecret = []byte("other-secret")
+
+	a, b := NewAuthenticator(admin), NewAuthenticator(other)
+
+	r, _ := http.NewRequest("GET", "/", nil)
+	w := httptest.NewRecorder()
+	a.SetUserCookie(w, r, &user{id: "jdoe"})
+	cookie := w.Result().Cookies()[0]
+	if cookie.Name != "_admin" {
+		t.Errorf("Expected cookie name _admin, got %s", cookie.Name)
+	}
+
+	r.AddCookie(cookie)
+	if u, err := a.GetUserCookie(r); err != nil || u.Id() != "jdoe" {
+		t.Errorf("Expected jdoe, got %v %v", u, err)
+	}
+
+	// the same cookie, under the other Authenticator's name, is rejected
+	r, _ = http.NewRequest("GET", "/", nil)
+	r.AddCookie(&http.Cookie{Name: other.CookieName, Value: cookie.Value})
+	if _, err := b.GetUserCookie(r); err == nil {
+		t.Errorf("Expected session rejected by the other Authenticator")
+	}
+
+	w = httptest.NewRecorder()
+	a.SecureFunc(func(w http.ResponseWriter, r *http.Request) {})(w, r)
+	if location := w.Header().Get("Location"); location != "/admin/login?next=%2F" {
+		t.Errorf("Expected redirect to /admin/login?next=%%2F, got %s", location)
+	}
+}
+
+// Test the ability to add the User to the request context using Middleware.
+func TestMiddleware(t *testing.T) {
+	r, _ := http.NewRequest("GET", "/", nil)
+	w := httptest.NewRecorder()
+	SetUserCookie(w, r, &user{id: "jdoe", name: "John Doe"})
+	r.AddCookie(w.Result().Cookies()[0])
+
+	var got User
+	handler := Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got = UserFromContext(r.Context())
+	}))
+	handler.ServeHTTP(httptest.NewRecorder(), r)
+
+	if got == nil || got.Name() != "John Doe" {
+		t.Errorf("Expected John Doe in the request context, got %v", got)
+	}
+
+	// requests without a session are redirected to the login page
+	r, _ = http.NewRequest("GET", "/", nil)
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, r)
+	if w.Code != http.StatusSeeOther {
+		t.Errorf("Expected redirect to login, got status %d", w.Code)
+	}
+}
+
+// Test the ability to replace the global Config after the package-level
+// functions have wrapped the handlers.
+func TestDefaultAuthenticator(t *testing.T) {
+	handler := SecureFunc(func(w http.ResponseWriter, r *http.Request) {})
+	middleware := Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+
+	old := Config
+	Config = NewConfig()
+	Config.LoginRedirect = "/signin"
+	defer func() { Config = old }()
+
+	r, _ := http.NewRequest("GET", "/", nil)
+	w := httptest.NewRecorder()
+	handler(w, r)
+	if location := w.Header().Get("Location"); location != "/signin?next=%2F" {
+		t.Errorf("Expected redirect to /signin?next=%%2F, got %s", location)
+	}
+
+	w = httptest.NewRecorder()
+	middleware.ServeHTTP(w, r)
+	if location := w.Header().Get("Location"); location != "/signin?next=%2F" {
+		t.Errorf("Expected redirect to /signin?next=%%2F, got %s", location)
+	}
+}
@@ -0,0 +1,112 @@
+package auth
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+)
+
+// ErrInvalidCredentials is returned when a username and password are not
+// accepted by the CredentialChecker.
+var ErrInvalidCredentials = errors.New("Invalid username or password")
+
+// A CredentialChecker verifies the username and password sent by a client
+// using HTTP Basic authentication.
+type CredentialChecker interface {
+
+	// Check returns true if the password is valid for the username.
+	Check(username, password string) bool
+}
+
+// CredentialCheckerFunc is an adapter that allows an ordinary function to
+// be used as a CredentialChecker.
+type CredentialCheckerFunc func(username, password string) bool
+
+func (f CredentialCheckerFunc) Check(username, password string) bool {
+	return f(username, password)
+}
+
+// BasicAuthProvider is an implementation of HTTP Basic authentication,
+// intended for machine logins to internal tools. Credentials are sent with
+// every request, so it must only be used over TLS.
+// See https://tools.ietf.org/html/rfc7617
+type BasicAuthProvider struct {
+	Realm   string
+	Checker CredentialChecker
+}
+
+// NewBasicAuthProvider allocates and returns a new BasicAuthProvider.
+func NewBasicAuthProvider(realm string, checker CredentialChecker) *BasicAuthProvider {
+	return &BasicAuthProvider{realm, checker}
+}
+
+// RedirectRequired returns a boolean value indicating if the request does
+// not include Basic credentials, and the client must be challenged.
+func (self *BasicAuthProvider) RedirectRequired(r *http.Request) bool {
+	_, _, ok := r.BasicAuth()
+	return !ok
+}
+
+// Redirect will challenge the client to send Basic credentials.
+func (self *BasicAuthProvider) Redirect(w http.ResponseWriter, r *http.Request) {
+	self.challenge(w)
+	http.Error(w, ErrAuthRequired.Error(), http.StatusUnauthorized)
+}
+
+// GetAuthenticatedUser will verify the Basic credentials, and return the
+// User. If the credentials are invalid the client is challenged again.
+func (self *BasicAuthProvider) GetAuthenticatedUser(w http.ResponseWriter, r *http.Request) (User, Token, error) {
+	username, password, ok := r.BasicAuth()
+	if !ok || !self.Checker.Check(username, password) {
+		self.challenge(w)
+		return nil, nil, ErrInvalidCredentials
+	}
+
+	u := user{
+		id:       username,
+		provider: "basic",
+		name:     username,
+	}
+	return &u, nil, nil
+}
+
+// challenge sets the WWW-Authenticate header for the realm.
+func (self *BasicAuthProvider) challenge(w http.ResponseWriter) {
+	w.Header().Set("WWW-Authenticate", fmt.Sprintf(`Basic realm=%q, charset="UTF-8"`, self.Realm))
+}
+
+// SecureBasic will attempt to verify the request's Basic credentials prior
+// to executing the auth.SecureHandlerFunc function. The User is also added
+// to the request's context. If the credentials are missing or invalid, the
+// client is challenged with a 401 response.
+func SecureBasic(realm string, checker CredentialChecker, handler SecureHandlerFunc) http.HandlerFunc {
+	p := NewBasicAuthProvider(realm, checker)
+	return func(w http.ResponseWriter, r *http.Request) {
+		secureChallenge(defaultAuthenticator(), p, handler)(w, r)
+	}
+}
+
+// SecureBasic will attempt to verify the request's Basic credentials prior
+// to executing the auth.SecureHandlerFunc function, using the Authenticator.
+func (self *Authenticator) SecureBasic(realm string, checker CredentialChecker, handler SecureHandlerFunc) http.HandlerFunc {
+	return secureChallenge(self, NewBasicAuthProvider(realm, checker), handler)
+}
+
+// secureChallenge authenticates every request using a provider that
+// challenges the client with a WWW-Authenticate header, such as the Basic
+// and Digest providers, rather than redirecting to a login page.
+func secureChallenge(a *Authenticator, p AuthProvider, handler SecureHandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if p.RedirectRequired(r) {
+			p.Redirect(w, r)
+			return
+		}
+
+		user, _, err := p.GetAuthenticatedUser(w, r)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusUnauthorized)
+			return
+		}
+		handler(w, a.withUser(r, user), user)
+	}
+}
@@ -0,0 +1,225 @@
+package auth
+
+import (
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Error messages related to Bearer Token validation
+var (
+	ErrInvalidToken      = errors.New("The access token is invalid or expired")
+	ErrInsufficientScope = errors.New("The access token does not have the required scope")
+	ErrInvalidRequest    = errors.New("The Authorization header is malformed")
+)
+
+// A TokenValidator validates an OAuth2 access token, presented by an API
+// client in the Authorization header, and returns the User the token was
+// issued to. An invalid or expired token must return an error that wraps
+// ErrInvalidToken.
+type TokenValidator interface {
+	Validate(token string) (User, error)
+}
+
+// TokenUser is the User returned by the TokenValidator implementations in
+// this package, representing the owner of an access token.
+type TokenUser struct {
+	Subject  string
+	Username string
+	ClientId string
+	Issuer   string
+	Scope    []string
+	Expires  time.Time
+
+	// Claims holds every claim of the token, or of the introspection
+	// response, including any non-standard claims.
+	Claims map[string]interface{}
+}
+
+func (u *TokenUser) Id() string       { return u.Subject }
+func (u *TokenUser) Provider() string { return u.Issuer }
+func (u *TokenUser) Name() string     { return u.Username }
+func (u *TokenUser) Email() string    { return u.claim("email") }
+func (u *TokenUser) Org() string      { return "" }
+func (u *TokenUser) Picture() string  { return u.claim("picture") }
+func (u *TokenUser) Link() string     { return u.claim("profile") }
+
+// Scopes returns the scopes granted to the access token.
+func (u *TokenUser) Scopes() []string { return u.Scope }
+
+func (u *TokenUser) claim(name string) string {
+	s, _ := u.Claims[name].(string)
+	return s
+}
+
+// SecureBearer will attempt to validate the Bearer Token in the request's
+// Authorization header prior to serving the request with the http.Handler.
+// The User is added to the request's context, and can be retrieved using
+// UserFromContext. If the token is missing or invalid, or is not granted all
+// of the specified scopes, an RFC 6750 error response is returned.
+func SecureBearer(v TokenValidator, handler http.Handler, scopes ...string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		defaultAuthenticator().SecureBearer(v, handler, scopes...).ServeHTTP(w, r)
+	})
+}
+
+// SecureBearer will attempt to validate the Bearer Token in the request's
+// Authorization header prior to serving the request with the http.Handler,
+// using the Authenticator's Realm.
+func (self *Authenticator) SecureBearer(v TokenValidator, handler http.Handler, scopes ...string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		token, err := bearerToken(r)
+		if err != nil {
+			self.bearerError(w, r, http.StatusBadRequest, "invalid_request", err, nil)
+			return
+		}
+		if len(token) == 0 {
+			self.bearerError(w, r, http.StatusUnauthorized, "", ErrAuthRequired, nil)
+			return
+		}
+
+		user, err := v.Validate(token)
+		switch {
+		case errors.Is(err, ErrInvalidToken):
+			self.bearerError(w, r, http.StatusUnauthorized, "invalid_token", err, nil)
+			return
+		case err != nil:
+			log.Printf("auth: unable to validate access token: %s", err)
+			writeJSONError(w, http.StatusServiceUnavailable, "temporarily_unavailable", "The access token could not be validated")
+			return
+		case !hasScopes(user, scopes):
+			self.bearerError(w, r, http.StatusForbidden, "insufficient_scope", ErrInsufficientScope, scopes)
+			return
+		}
+
+		handler.ServeHTTP(w, self.withUser(r, user))
+	})
+}
+
+// bearerToken returns the token from the Authorization header, or an empty
+// string if the request does not have a Bearer Token.
+//
+// See https://tools.ietf.org/html/rfc6750#section-2.1
+func bearerToken(r *http.Request) (string, error) {
+	header := r.Header.Values("Authorization")
+	switch {
+	case len(header) == 0:
+		return "", nil
+	case len(header) > 1:
+		return "", ErrInvalidRequest
+	}
+
+	scheme, token, _ := strings.Cut(header[0], " ")
+	if !strings.EqualFold(scheme, "Bearer") {
+		return "", nil
+	}
+	token = strings.TrimSpace(token)
+	if len(token) == 0 || strings.ContainsAny(token, " \t") {
+		return "", ErrInvalidRequest
+	}
+	return token, nil
+}
+
+// bearerError writes an RFC 6750 error response, with a WWW-Authenticate
+// challenge. The error code is omitted when the request has no token.
+//
+// See https://tools.ietf.org/html/rfc6750#section-3
+func (self *Authenticator) bearerError(w http.ResponseWriter, r *http.Request, status int, code string, err error, scopes []string) {
+	challenge := fmt.Sprintf("Bearer realm=%q", self.config.realm(r))
+	if len(code) > 0 {
+		challenge += fmt.Sprintf(", error=%q, error_description=%q", code, err.Error())
+	}
+	if len(scopes) > 0 {
+		challenge += fmt.Sprintf(", scope=%q", strings.Join(scopes, " "))
+	}
+	w.Header().Set("WWW-Authenticate", challenge)
+
+	if len(code) == 0 {
+		code = "unauthorized"
+	}
+	writeJSONError(w, status, code, err.Error())
+}
+
+// hasScopes returns true if the User was granted all of the scopes.
+func hasScopes(u User, scopes []string) bool {
+	if len(scopes) == 0 {
+		return true
+	}
+	scoped, ok := u.(interface{ Scopes() []string })
+	if !ok {
+		return false
+	}
+
+	granted := map[string]bool{}
+	for _, scope := range scoped.Scopes() {
+		granted[scope] = true
+	}
+	for _, scope := range scopes {
+		if !granted[scope] {
+			return false
+		}
+	}
+	return true
+}
+
+// CachingValidator is a TokenValidator that caches the Users returned by
+// another TokenValidator, so that a token is not validated on every request.
+// Users are cached until the TTL elapses or the token expires, whichever is
+// first. Invalid tokens are not cached.
+type CachingValidator struct {
+	Validator TokenValidator
+	TTL       time.Duration
+
+	sync.Mutex
+	entries map[[sha256.Size]byte]cachedToken
+}
+
+type cachedToken struct {
+	user    User
+	expires time.Time
+}
+
+// NewCachingValidator allocates and returns a new CachingValidator.
+func NewCachingValidator(v TokenValidator, ttl time.Duration) *CachingValidator {
+	return &CachingValidator{Validator: v, TTL: ttl, entries: map[[sha256.Size]byte]cachedToken{}}
+}
+
+func (self *CachingValidator) Validate(token string) (User, error) {
+	// tokens are cached by their hash, so they aren't kept in memory
+	key := sha256.Sum256([]byte(token))
+	now := time.Now()
+
+	self.Lock()
+	entry, ok := self.entries[key]
+	self.Unlock()
+	if ok && now.Before(entry.expires) {
+		return entry.user, nil
+	}
+
+	user, err := self.Validator.Validate(token)
+	if err != nil {
+		return nil, err
+	}
+
+	expires := now.Add(self.TTL)
+	if tu, ok := user.(*TokenUser); ok && !tu.Expires.IsZero() && tu.Expires.Before(expires) {
+		expires = tu.Expires
+	}
+
+	self.Lock()
+	defer self.Unlock()
+
+	//remove tokens that have expired
+	for k, e := range self.entries {
+		if now.After(e.expires) {
+			delete(self.entries, k)
+		}
+	}
+	self.entries[key] = cachedToken{user, expires}
+	return user, nil
+}
@@ -0,0 +1,121 @@
+package auth
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// IntrospectionValidator is a TokenValidator that validates access tokens
+// using the Authorization Server's token introspection endpoint. Wrap it in
+// a CachingValidator to avoid a request for every API call.
+//
+// See https://tools.ietf.org/html/rfc7662
+type IntrospectionValidator struct {
+	Endpoint     string
+	ClientId     string
+	ClientSecret string
+
+	// Audience, if set, must be included in the aud of the token.
+	Audience string
+}
+
+// NewIntrospectionValidator allocates and returns a new
+// IntrospectionValidator, authenticating to the endpoint with the
+// specified client credentials.
+func NewIntrospectionValidator(endpoint, clientId, clientSecret string) *IntrospectionValidator {
+	return &IntrospectionValidator{Endpoint: endpoint, ClientId: clientId, ClientSecret: clientSecret}
+}
+
+// introspection is the subset of the introspection response used to create
+// the TokenUser.
+type introspection struct {
+	Active    bool   `json:"active"`
+	Scope     string `json:"scope"`
+	ClientId  string `json:"client_id"`
+	Username  string `json:"username"`
+	TokenType string `json:"token_type"`
+	Expiry    int64  `json:"exp"`
+	Subject   string `json:"sub"`
+	Issuer    string `json:"iss"`
+}
+
+func (self *IntrospectionValidator) Validate(token string) (User, error) {
+	params := url.Values{
+		"token":           {token},
+		"token_type_hint": {"access_token"},
+	}
+	req, err := http.NewRequest("POST", self.Endpoint, strings.NewReader(params.Encode()))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+	req.SetBasicAuth(url.QueryEscape(self.ClientId), url.QueryEscape(self.ClientSecret))
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("Token introspection failed, status %d", resp.StatusCode)
+	}
+
+	claims := map[string]interface{}{}
+	if err := json.NewDecoder(resp.Body).Decode(&claims); err != nil {
+		return nil, err
+	}
+	info := introspection{}
+	raw, _ := json.Marshal(claims)
+	if err := json.Unmarshal(raw, &info); err != nil {
+		return nil, err
+	}
+
+	// an inactive token may be unknown, expired or revoked
+	if !info.Active {
+		return nil, ErrInvalidToken
+	}
+	if len(info.TokenType) > 0 && !strings.EqualFold(info.TokenType, "Bearer") &&
+		!strings.EqualFold(info.TokenType, "access_token") {
+		return nil, ErrInvalidToken
+	}
+	if info.Expiry != 0 && time.Now().After(time.Unix(info.Expiry, 0)) {
+		return nil, ErrInvalidToken
+	}
+	if len(self.Audience) > 0 && !claimContains(claims["aud"], self.Audience) {
+		return nil, ErrInvalidToken
+	}
+
+	user := TokenUser{
+		Subject:  info.Subject,
+		Username: info.Username,
+		ClientId: info.ClientId,
+		Issuer:   info.Issuer,
+		Scope:    strings.Fields(info.Scope),
+		Claims:   claims,
+	}
+	if info.Expiry != 0 {
+		user.Expires = time.Unix(info.Expiry, 0)
+	}
+	return &user, nil
+}
+
+// claimContains returns true if the claim is the value, or is an array
+// containing the value.
+func claimContains(claim interface{}, value string) bool {
+	switch v := claim.(type) {
+	case string:
+		return v == value
+	case []interface{}:
+		for _, item := range v {
+			if item == value {
+				return true
+			}
+		}
+	}
+	return false
+}
@@ -0,0 +1,99 @@
+package auth
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/bradrydzewski/go.auth/jwt"
+)
+
+// JWTValidator is a TokenValidator that validates JWT access tokens, signed
+// by the Authorization Server, without a request to the server.
+//
+// See https://tools.ietf.org/html/rfc9068
+type JWTValidator struct {
+	Keys     jwt.KeySource
+	Issuer   string
+	Audience string
+
+	// The clock skew tolerated when validating the exp and iat claims.
+	Leeway time.Duration
+}
+
+// NewJWTValidator allocates and returns a new JWTValidator, that verifies
+// tokens using the JSON Web Key Set at the specified URL.
+func NewJWTValidator(issuer, audience, jwksURL string) *JWTValidator {
+	return &JWTValidator{
+		Keys:     jwt.NewRemoteKeySet(jwksURL),
+		Issuer:   issuer,
+		Audience: audience,
+		Leeway:   time.Minute,
+	}
+}
+
+func (self *JWTValidator) Validate(token string) (User, error) {
+	parsed, err := jwt.Verify(token, self.Keys)
+	switch {
+	case err == nil:
+	case isInvalidJWT(err):
+		return nil, fmt.Errorf("%w: %v", ErrInvalidToken, err)
+	default:
+		// the key set could not be fetched, so the token can't be
+		// validated until the Authorization Server is available
+		return nil, err
+	}
+
+	// the typ header prevents other JWTs, such as ID Tokens, from being
+	// used as access tokens
+	typ := strings.ToLower(parsed.Header.Type)
+	if typ != "at+jwt" && typ != "application/at+jwt" {
+		return nil, fmt.Errorf("%w: typ is not at+jwt", ErrInvalidToken)
+	}
+
+	claims := struct {
+		jwt.Claims
+		ClientId string `json:"client_id"`
+		Scope    string `json:"scope"`
+		Username string `json:"preferred_username"`
+	}{}
+	if err := parsed.Claims(&claims); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidToken, err)
+	}
+
+	validator := jwt.Validator{
+		Issuer:   self.Issuer,
+		Audience: self.Audience,
+		Leeway:   self.Leeway,
+		Required: []string{"iss", "exp", "aud", "sub", "iat"},
+	}
+	if err := validator.Validate(&claims.Claims); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidToken, err)
+	}
+	if len(claims.ClientId) == 0 {
+		return nil, fmt.Errorf("%w: client_id is missing", ErrInvalidToken)
+	}
+
+	user := TokenUser{
+		Subject:  claims.Subject,
+		Username: claims.Username,
+		ClientId: claims.ClientId,
+		Issuer:   claims.Issuer,
+		Scope:    strings.Fields(claims.Scope),
+		Expires:  claims.Expiry.Time(),
+	}
+	if err := parsed.Claims(&user.Claims); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidToken, err)
+	}
+	return &user, nil
+}
+
+// isInvalidJWT returns true if the error was caused by the token, rather than
+// by a failure to fetch the key set.
+func isInvalidJWT(err error) bool {
+	return errors.Is(err, jwt.ErrMalformed) ||
+		errors.Is(err, jwt.ErrUnsupportedAlgorithm) ||
+		errors.Is(err, jwt.ErrInvalidSignature) ||
+		errors.Is(err, jwt.ErrKeyNotFound)
+}
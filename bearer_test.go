@@ -0,0 +1,149 @@
+package auth
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/bradrydzewski/go.auth/jwt"
+)
+
+// Test the ability to secure an API with Bearer Tokens, validated using
+// token introspection, and to cache the introspection results.
+func TestSecureBearer(t *testing.T) {
+	calls := 0
+	as := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		if id, secret, _ := r.BasicAuth(); id != "api" || secret != "secret" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		if r.FormValue("token") == "valid" {
+			fmt.Fprint(w, `{"active":true,"sub":"42","username":"jdoe","scope":"read write","client_id":"app"}`)
+		} else {
+			fmt.Fprint(w, `{"active":false}`)
+		}
+	}))
+	defer as.Close()
+
+	validator := NewCachingValidator(NewIntrospectionValidator(as.URL, "api", "secret"), time.Minute)
+	var got User
+	handler := SecureBearer(validator, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got = UserFromContext(r.Context())
+	}), "read")
+
+	request := func(authorization string) *httptest.ResponseRecorder {
+		r, _ := http.NewRequest("GET", "/api", nil)
+		if len(authorization) > 0 {
+			r.Header.Set("Authorization", authorization)
+		}
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, r)
+		return w
+	}
+
+	request("Bearer valid")
+	request("Bearer valid")
+	if got == nil || got.Name() != "jdoe" {
+		t.Errorf("Expected jdoe in the request context, got %v", got)
+	}
+	if calls != 1 {
+		t.Errorf("Expected introspection result cached, got %d calls", calls)
+	}
+
+	w := request("")
+	if w.Code != http.StatusUnauthorized || w.Header().Get("WWW-Authenticate") != `Bearer realm=""` {
+		t.Errorf("Expected 401 with a Bearer challenge, got %d %q", w.Code, w.Header().Get("WWW-Authenticate"))
+	}
+
+	w = request("Bearer revoked")
+	if w.Code != http.StatusUnauthorized || !strings.Contains(w.Header().Get("WWW-Authenticate"), `error="invalid_token"`) {
+		t.Errorf("Expected 401 invalid_token, got %d %q", w.Code, w.Header().Get("WWW-Authenticate"))
+	}
+
+	handler = SecureBearer(validator, http.NotFoundHandler(), "admin")
+	w = request("Bearer valid")
+	if w.Code != http.StatusForbidden || !strings.Contains(w.Header().Get("WWW-Authenticate"), `error="insufficient_scope"`) {
+		t.Errorf("Expected 403 insufficient_scope, got %d %q", w.Code, w.Header().Get("WWW-Authenticate"))
+	}
+}
+
+// Test the ability to validate a JWT access token, and reject other JWTs.
+func TestJWTValidator(t *testing.T) {
+	key, _ := rsa.GenerateKey(rand.Reader, 2048)
+	keys := &jwt.KeySet{Keys: []jwt.JSONWebKey{{
+		KeyType: "RSA",
+		KeyId:   "k1",
+		N:       base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+		E:       base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+	}}}
+	validator := &JWTValidator{Keys: keys, Issuer: "https://as.example.com", Audience: "https://api.example.com"}
+
+	sign := func(typ string, claims map[string]interface{}) string {
+		header, _ := json.Marshal(map[string]string{"alg": "RS256", "kid": "k1", "typ": typ})
+		payload, _ := json.Marshal(claims)
+		signed := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(payload)
+		sum := sha256.Sum256([]byte(signed))
+		sig, _ := rsa.SignPKCS1v15(rand.Reader, key, crypto.SHA256, sum[:])
+		return signed + "." + base64.RawURLEncoding.EncodeToString(sig)
+	}
+
+	now := time.Now().Unix()
+	claims := map[string]interface{}{
+		"iss": "https://as.example.com", "sub": "42", "aud": "https://api.example.com",
+		"exp": now + 60, "iat": now, "client_id": "app", "scope": "read",
+	}
+
+	u, err := validator.Validate(sign("at+jwt", claims))
+	if err != nil {
+		t.Fatalf("Expected access token validated, got Error %s", err.Error())
+	}
+	if u.Id() != "42" || !hasScopes(u, []string{"read"}) {
+		t.Errorf("Expected User 42 with scope read, got %v", u)
+	}
+
+	if _, err := validator.Validate(sign("JWT", claims)); !errors.Is(err, ErrInvalidToken) {
+		t.Errorf("Expected ErrInvalidToken for typ JWT, got %v", err)
+	}
+
+	claims["aud"] = "https://other.example.com"
+	if _, err := validator.Validate(sign("at+jwt", claims)); !errors.Is(err, ErrInvalidToken) {
+		t.Errorf("Expected ErrInvalidToken for audience, got %v", err)
+	}
+}
+
+// Test the ability to distinguish a JWT that can't be validated because the
+// key set is unavailable from an invalid JWT.
+func TestJWTValidatorUnavailable(t *testing.T) {
+	jwks := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer jwks.Close()
+
+	header := base64.RawURLEncoding.EncodeToString([]byte(`{"alg":"RS256","kid":"k1","typ":"at+jwt"}`))
+	token := header + "." + base64.RawURLEncoding.EncodeToString([]byte(`{}`)) + ".c2ln"
+
+	validator := NewJWTValidator("https://as.example.com", "https://api.example.com", jwks.URL)
+	if _, err := validator.Validate(token); err == nil || errors.Is(err, ErrInvalidToken) {
+		t.Errorf("Expected key set error, got %v", err)
+	}
+
+	w := httptest.NewRecorder()
+	r, _ := http.NewRequest("GET", "/api", nil)
+	r.Header.Set("Authorization", "Bearer "+token)
+	SecureBearer(validator, http.NotFoundHandler()).ServeHTTP(w, r)
+	if w.Code != http.StatusServiceUnavailable || strings.Contains(w.Body.String(), "500") {
+		t.Errorf("Expected 503 without the key set error, got %d %s", w.Code, w.Body.String())
+	}
+}
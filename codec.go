@@ -0,0 +1,142 @@
+package auth
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"time"
+
+	"github.com/dchest/authcookie"
+)
+
+// ErrCookieTampered is returned when a cookie value cannot be decrypted
+// or authenticated.
+var ErrCookieTampered = errors.New("Cookie could not be authenticated")
+
+// A Codec encodes and decodes the values of the secure cookies written by
+// this package. A custom Codec can be provided using Config.CookieCodec.
+type Codec interface {
+
+	// Encode returns the cookie value for the specified cookie name, data
+	// and expiration time.
+	Encode(name, data string, expires time.Time) (string, error)
+
+	// Decode returns the data and expiration time from the cookie value.
+	// An error is returned if the value was not created by Encode for the
+	// same cookie name.
+	Decode(name, value string) (string, time.Time, error)
+}
+
+// SignedCodec is a Codec that signs the cookie using authcookie. The data
+// is stored in the cookie as readable text. The cookie is signed with a key
+// derived from the Secret and the cookie name, so that a value cannot be
+// moved to a different cookie.
+type SignedCodec struct {
+	Secret []byte
+}
+
+// NewSignedCodec allocates and returns a new SignedCodec.
+func NewSignedCodec(secret []byte) *SignedCodec {
+	return &SignedCodec{secret}
+}
+
+func (self *SignedCodec) Encode(name, data string, expires time.Time) (string, error) {
+	return authcookie.New(data, expires, self.key(name)), nil
+}
+
+func (self *SignedCodec) Decode(name, value string) (string, time.Time, error) {
+	return authcookie.Parse(value, self.key(name))
+}
+
+// key returns the signing key for the cookie name.
+func (self *SignedCodec) key(name string) []byte {
+	mac := hmac.New(sha256.New, self.Secret)
+	mac.Write([]byte("go.auth cookie " + name))
+	return mac.Sum(nil)
+}
+
+// decodeLegacy decodes a value that was signed using the Secret, before
+// the cookie name was included in the signature.
+func (self *SignedCodec) decodeLegacy(value string) (string, time.Time, error) {
+	return authcookie.Parse(value, self.Secret)
+}
+
+// legacyCodec is implemented by a Codec that can decode values written
+// before the cookie name was included in the signature. Only session
+// cookies are decoded this way, so that existing sessions remain valid
+// until they are re-issued.
+type legacyCodec interface {
+	decodeLegacy(value string) (string, time.Time, error)
+}
+
+// EncryptedCodec is a Codec that encrypts and authenticates the cookie
+// using AES-256 in GCM mode, so that the data cannot be read or modified
+// by the holder of the cookie. The cookie name is authenticated along with
+// the data, so that a value cannot be moved to a different cookie.
+type EncryptedCodec struct {
+	aead cipher.AEAD
+}
+
+// NewEncryptedCodec allocates and returns a new EncryptedCodec. The AES key
+// is derived from the secret, which should be at least 32 random bytes.
+func NewEncryptedCodec(secret []byte) *EncryptedCodec {
+	// derive a key, so the secret isn't used directly for both the
+	// signed and encrypted codecs
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte("go.auth cookie encryption"))
+
+	block, err := aes.NewCipher(mac.Sum(nil))
+	if err != nil {
+		panic(err)
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		panic(err)
+	}
+	return &EncryptedCodec{aead}
+}
+
+func (self *EncryptedCodec) Encode(name, data string, expires time.Time) (string, error) {
+	nonce := make([]byte, self.aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return "", err
+	}
+
+	// the plaintext is the expiration time followed by the data
+	plaintext := make([]byte, 8+len(data))
+	binary.BigEndian.PutUint64(plaintext, uint64(expires.Unix()))
+	copy(plaintext[8:], data)
+
+	sealed := self.aead.Seal(nonce, nonce, plaintext, []byte(name))
+	return base64.RawURLEncoding.EncodeToString(sealed), nil
+}
+
+func (self *EncryptedCodec) Decode(name, value string) (string, time.Time, error) {
+	sealed, err := base64.RawURLEncoding.DecodeString(value)
+	if err != nil || len(sealed) < self.aead.NonceSize() {
+		return "", time.Time{}, ErrInvalidCookieFormat
+	}
+
+	nonce := sealed[:self.aead.NonceSize()]
+	plaintext, err := self.aead.Open(nil, nonce, sealed[len(nonce):], []byte(name))
+	if err != nil || len(plaintext) < 8 {
+		return "", time.Time{}, ErrCookieTampered
+	}
+
+	expires := time.Unix(int64(binary.BigEndian.Uint64(plaintext)), 0)
+	return string(plaintext[8:]), expires, nil
+}
+
+// codec returns the Codec used to encode and decode cookies, defaulting to
+// a SignedCodec using the CookieSecret.
+func (self *AuthConfig) codec() Codec {
+	if self.CookieCodec != nil {
+		return self.CookieCodec
+	}
+	return NewSignedCodec(self.CookieSecret)
+}
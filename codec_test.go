@@ -0,0 +1,91 @@
+package auth
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/dchest/authcookie"
+)
+
+// Test the ability to sign a cookie value, so that it can't be moved to a
+// different cookie.
+func TestSignedCodec(t *testing.T) {
+	codec := NewSignedCodec([]byte("7H9xiimk2QdTdYI7rDddfJeV"))
+	exp := time.Now().Add(time.Hour).Truncate(time.Second)
+
+	value, _ := codec.Encode("_state_abc", "state=abc", exp)
+	data, expires, err := codec.Decode("_state_abc", value)
+	if err != nil || data != "state=abc" || !expires.Equal(exp) {
+		t.Errorf("Expected state=abc expiring %v, got %v expiring %v, Error %v", exp, data, expires, err)
+	}
+	if _, _, err := codec.Decode("_sess", value); err == nil {
+		t.Errorf("Expected an error decoding the value for a different cookie")
+	}
+}
+
+// Test the ability to accept a session cookie signed before the cookie name
+// was included in the signature, and re-issue it.
+func TestLegacySignedCookie(t *testing.T) {
+	config := NewConfig()
+	config.CookieSecret = []byte("7H9xiimk2QdTdYI7rDddfJeV")
+	data, _ := encodeUserCookie(&user{id: "jdoe"}, time.Now())
+	legacy := authcookie.New(data, time.Now().Add(time.Hour), config.CookieSecret)
+
+	r, _ := http.NewRequest("GET", "/", nil)
+	r.AddCookie(&http.Cookie{Name: config.CookieName, Value: legacy})
+	w := httptest.NewRecorder()
+	u, err := config.getSessionUser(w, r)
+	if err != nil || u.Id() != "jdoe" {
+		t.Fatalf("Expected legacy session cookie accepted, got %v %v", u, err)
+	}
+	cookies := w.Result().Cookies()
+	if len(cookies) == 0 {
+		t.Fatalf("Expected legacy session cookie re-issued")
+	}
+	if _, _, err := config.codec().Decode(cookies[0].Name, cookies[0].Value); err != nil {
+		t.Errorf("Expected re-issued cookie signed with the cookie name, got Error %s", err.Error())
+	}
+
+	// only the session cookie is accepted in the legacy format
+	r, _ = http.NewRequest("GET", "/", nil)
+	r.AddCookie(&http.Cookie{Name: "_other", Value: legacy})
+	if _, _, err := config.readCookie(r, "_other"); err == nil {
+		t.Errorf("Expected legacy value rejected for another cookie")
+	}
+}
+
+// Test the ability to encrypt and decrypt a cookie value.
+func TestEncryptedCodec(t *testing.T) {
+	codec := NewEncryptedCodec([]byte("7H9xiimk2QdTdYI7rDddfJeV"))
+	exp := time.Now().Add(time.Hour).Truncate(time.Second)
+
+	value, err := codec.Encode("_sess", "jdoe@example.com", exp)
+	if err != nil {
+		t.Fatalf("Expected cookie encoded, got Error %s", err.Error())
+	}
+	if strings.Contains(value, "jdoe") {
+		t.Errorf("Expected cookie data encrypted, got %v", value)
+	}
+
+	data, expires, err := codec.Decode("_sess", value)
+	if err != nil {
+		t.Fatalf("Expected cookie decoded, got Error %s", err.Error())
+	}
+	if data != "jdoe@example.com" || !expires.Equal(exp) {
+		t.Errorf("Expected jdoe@example.com expiring %v, got %v expiring %v", exp, data, expires)
+	}
+
+	// the value cannot be moved to a different cookie
+	if _, _, err := codec.Decode("_other", value); err != ErrCookieTampered {
+		t.Errorf("Expected ErrCookieTampered for cookie name, got %v", err)
+	}
+
+	// the value cannot be decrypted with a different secret
+	other := NewEncryptedCodec([]byte("asdfasdfasfasdfasdfafsd"))
+	if _, _, err := other.Decode("_sess", value); err != ErrCookieTampered {
+		t.Errorf("Expected ErrCookieTampered for secret, got %v", err)
+	}
+}
@@ -0,0 +1,59 @@
+package auth
+
+import (
+	"context"
+	"net/http"
+)
+
+// userKey is the context key of the authenticated User.
+type userKey struct{}
+
+// WithUser returns a copy of the context that holds the User.
+func WithUser(ctx context.Context, u User) context.Context {
+	return context.WithValue(ctx, userKey{}, u)
+}
+
+// UserFromContext returns the User stored in the context by Middleware,
+// SecureFunc, SecureUser or WithUser. It returns nil if the context does not
+// hold a User.
+func UserFromContext(ctx context.Context) User {
+	u, _ := ctx.Value(userKey{}).(User)
+	return u
+}
+
+// Middleware will attempt to verify a user session exists prior to serving
+// the request with the http.Handler. The User is added to the request's
+// context, and can be retrieved using UserFromContext. If no valid sessions
+// exists, the user will be redirected to the Config.LoginRedirect Url, or an
+// API request will receive a 401 response.
+func Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		defaultAuthenticator().Middleware(next).ServeHTTP(w, r)
+	})
+}
+
+// Middleware will attempt to verify a user session exists prior to serving
+// the request with the http.Handler, using the Authenticator's config.
+func (self *Authenticator) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		user, err := self.config.getSessionUser(w, r)
+
+		//if no active user session then authorize user
+		if err != nil || user.Id() == "" {
+			self.unauthenticated(w, r, err)
+			return
+		}
+
+		next.ServeHTTP(w, self.withUser(r, user))
+	})
+}
+
+// withUser returns a copy of the request, with the Authenticator and the
+// User added to its context.
+func (self *Authenticator) withUser(r *http.Request, u User) *http.Request {
+	ctx := context.WithValue(r.Context(), authenticatorKey{}, self)
+	if u != nil {
+		ctx = WithUser(ctx, u)
+	}
+	return r.WithContext(ctx)
+}
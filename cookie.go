@@ -1,9 +1,9 @@
 package auth
 
 import (
+	"encoding/json"
 	"errors"
 	"fmt"
-	"github.com/dchest/authcookie"
 	"net/http"
 	"strings"
 	"time"
@@ -16,110 +16,389 @@ var (
 )
 
 // SetUserCookie creates a secure cookie for the given username, indicating the
-// user is authenticated.
-func SetUserCookie(w http.ResponseWriter, r *http.Request, user User) {
-
-	cookie := &http.Cookie{
-		Name:     Config.CookieName,
-		Path:     "/",
-		Domain:   r.URL.Host,
-		HttpOnly: Config.CookieHttpOnly,
-		Secure:   Config.CookieSecure,
-	}
+// user is authenticated. If a SessionStore is configured, any existing
+// session for the request is deleted and replaced by a new session.
+func SetUserCookie(w http.ResponseWriter, r *http.Request, user User) error {
+	return authenticatorFor(r).SetUserCookie(w, r, user)
+}
 
-	// if not a session cookie set the MaxAge
-	if Config.CookieMaxAge > 0 {
-		cookie.MaxAge = Config.CookieMaxAge
-	}
+// SetUserCookie creates a secure cookie for the given User, using the
+// Authenticator's config.
+func (self *Authenticator) SetUserCookie(w http.ResponseWriter, r *http.Request, user User) error {
+	self.config.deleteSession(r)
+	return self.SetUserCookieOpts(w, self.config.newUserCookie(), user)
+}
 
-	SetUserCookieOpts(w, cookie, user)
+// SetUserCookieOpts creates a secure cookie for the given User and with the
+// specified cookie options. The cookie value is encoded using the
+// Config.CookieCodec.
+func SetUserCookieOpts(w http.ResponseWriter, cookie *http.Cookie, user User) error {
+	return defaultAuthenticator().SetUserCookieOpts(w, cookie, user)
 }
 
 // SetUserCookieOpts creates a secure cookie for the given User and with the
-// specified cookie options.
-func SetUserCookieOpts(w http.ResponseWriter, cookie *http.Cookie, user User) {
+// specified cookie options, using the Authenticator's config.
+func (self *Authenticator) SetUserCookieOpts(w http.ResponseWriter, cookie *http.Cookie, user User) error {
 
 	// default cookie expiration
-	exp := time.Now().Add(Config.CookieExp)
+	now := time.Now()
+	return self.config.setUserCookie(w, cookie, user, now, self.config.sessionExpiry(now, now))
+}
+
+// newUserCookie returns the session cookie, with the options specified in
+// the Config.
+func (self *AuthConfig) newUserCookie() *http.Cookie {
+	cookie := self.newCookie(self.CookieName)
+
+	// if not a session cookie set the MaxAge
+	if self.CookieMaxAge > 0 {
+		cookie.MaxAge = self.CookieMaxAge
+	}
+	return cookie
+}
 
-	// generate cookie valid for 24 hours for user
-	// the strings are quoted to ensure they aren't tampered with
-	// TODO explore storing string as a URL Parameter String
-	userStr := fmt.Sprintf("%q|%q|%q|%q|%q|%q|%q",
-							user.Id(), user.Provider(), user.Name(),
-							user.Email(), user.Link(), user.Picture(),
-							user.Org())
+// setUserCookie creates a secure cookie for the given User, that logged in
+// at the created time and expires at the specified time. If a SessionStore
+// is configured, the User is saved in a new server-side session, and the
+// cookie holds only the session id.
+func (self *AuthConfig) setUserCookie(w http.ResponseWriter, cookie *http.Cookie, user User, created, exp time.Time) error {
+
+	// store the user server-side, using a new session id every time
+	// the user logs in to prevent session fixation
+	if self.SessionStore != nil {
+		session, err := self.newSession(user, created)
+		if err != nil {
+			return err
+		}
+		return self.writeCookie(w, cookie, session.Id, exp)
+	}
+
+	userStr, err := encodeUserCookie(user, created)
+	if err != nil {
+		return err
+	}
+	return self.writeCookie(w, cookie, userStr, exp)
+}
+
+// writeCookie encodes the data using the CookieCodec and sets the
+// cookie's value.
+func (self *AuthConfig) writeCookie(w http.ResponseWriter, cookie *http.Cookie, data string, exp time.Time) error {
 
 	// set the cookie's value
-	cookie.Value = authcookie.New(userStr, exp, Config.CookieSecret)
+	value, err := self.codec().Encode(cookie.Name, data, exp)
+	if err != nil {
+		return err
+	}
+	cookie.Value = value
 
 	// set the cookie
-	http.SetCookie(w, cookie)
+	self.setCookie(w, cookie)
+	return nil
 }
 
 // DeleteUserCookie removes a secure cookie that was created for the user's
-// login session. This effectively logs a user out of the system.
+// login session. This effectively logs a user out of the system. If a
+// SessionStore is configured the server-side session is deleted as well.
 func DeleteUserCookie(w http.ResponseWriter, r *http.Request) {
-	DeleteUserCookieName(w, r, Config.CookieName)
+	authenticatorFor(r).DeleteUserCookie(w, r)
 }
 
-// DeleteUserCookieName removes a secure cookie with the specified name.
+// DeleteUserCookie logs the user out, removing the session cookie and the
+// server-side session.
+func (self *Authenticator) DeleteUserCookie(w http.ResponseWriter, r *http.Request) {
+	self.config.deleteSession(r)
+	self.DeleteUserCookieName(w, r, self.config.CookieName)
+}
+
+// DeleteUserCookieName removes a secure cookie with the specified name. The
+// cookie is deleted using the attributes of the Config.CookiePolicy.
 func DeleteUserCookieName(w http.ResponseWriter, r *http.Request, name string) {
-	cookie := http.Cookie{
-		Name:   name,
-		Value:  "deleted",
-		Path:   "/",
-		Domain: r.URL.Host,
-		MaxAge: -1,
-	}
+	authenticatorFor(r).DeleteUserCookieName(w, r, name)
+}
 
-	http.SetCookie(w, &cookie)
+// DeleteUserCookieName removes a secure cookie with the specified name.
+func (self *Authenticator) DeleteUserCookieName(w http.ResponseWriter, r *http.Request, name string) {
+	self.config.deleteCookie(w, name)
 }
 
 // GetUserCookie will get the User data from the http session. If the session is
 // inactive, or if the session has expired, then an error will be returned.
 func GetUserCookie(r *http.Request) (User, error) {
-	return GetUserCookieName(r, Config.CookieName)
+	return authenticatorFor(r).GetUserCookie(r)
+}
+
+// GetUserCookie will get the User data from the http session.
+func (self *Authenticator) GetUserCookie(r *http.Request) (User, error) {
+	return self.GetUserCookieName(r, self.config.CookieName)
 }
 
 // GetUserCookieName will get the User data from the http session for the 
 // specified secure cookie. If the session is inactive, or if the session has
 // expired, then an error will be returned.
 func GetUserCookieName(r *http.Request, name string) (User, error) {
+	return authenticatorFor(r).GetUserCookieName(r, name)
+}
+
+// GetUserCookieName will get the User data from the specified secure cookie.
+func (self *Authenticator) GetUserCookieName(r *http.Request, name string) (User, error) {
+	u, _, err := self.config.getUserCookie(r, name)
+	if err != nil {
+		return nil, err
+	}
+	return u, nil
+}
+
+// getUserCookie will get the User data and the login time from the
+// specified secure cookie.
+func (self *AuthConfig) getUserCookie(r *http.Request, name string) (User, time.Time, error) {
+	data, expires, err := self.readCookie(r, name)
+	if err != nil {
+		return nil, time.Time{}, err
+	}
+
+	// the cookie holds the id of a server-side session
+	if self.SessionStore != nil {
+		return self.getSession(data)
+	}
+
+	// parse the user data from the cookie string
+	u, created, err := self.decodeUserCookie(data, expires)
+	if err != nil {
+		return nil, time.Time{}, err
+	}
+
+	// enforce the absolute timeout, in case it was reduced after the
+	// cookie was written
+	if time.Now().After(created.Add(self.maxLifetime())) {
+		return nil, time.Time{}, ErrSessionExpired
+	}
+	return u, created, nil
+}
+
+// readCookie will decode the data and the expiration time from the
+// specified secure cookie.
+func (self *AuthConfig) readCookie(r *http.Request, name string) (string, time.Time, error) {
 	//look for the authcookie
-	cookie, err := r.Cookie(name)
+	cookie, err := r.Cookie(self.cookieName(name))
 
 	//if doesn't exist (or is malformed) redirect
 	//back to the login url
 	if err != nil {
-		return nil, err
+		return "", time.Time{}, err
 	}
 
-	// get the login string from authcookie
-	login, expires, err := authcookie.Parse(cookie.Value, Config.CookieSecret)
+	// get the login string from the cookie codec
+	data, expires, err := self.codec().Decode(cookie.Name, cookie.Value)
+
+	// a session cookie signed before the cookie name was included in the
+	// signature is accepted, and re-issued by getSessionUser
+	if legacy, ok := self.codec().(legacyCodec); ok && err != nil && name == self.CookieName {
+		data, expires, err = legacy.decodeLegacy(cookie.Value)
+	}
 
 	//if there was an error parsing the cookie, redirect
 	//back to the login url
 	if err != nil {
-		return nil, err
+		return "", time.Time{}, err
 	}
 
 	//if the cookie is expired, redirect back to the
 	//login url
 	if time.Now().After(expires) {
-		return nil, ErrSessionExpired
+		return "", time.Time{}, ErrSessionExpired
 	}
 
-	// parse the user data from the cookie string
+	return data, expires, nil
+}
+
+// getSessionUser will get the User data from the session cookie. The cookie
+// is re-issued if it is close to the idle timeout, extending the session up
+// to the absolute timeout. If the cookie was encoded with a previous key,
+// and CookieReissue is enabled, it is also re-issued using the
+// current key.
+func (self *AuthConfig) getSessionUser(w http.ResponseWriter, r *http.Request) (User, error) {
+	u, created, err := self.getUserCookie(r, self.CookieName)
+	if err != nil {
+		return nil, err
+	}
+
+	data, expires, _ := self.readCookie(r, self.CookieName)
+	reissue := false
+
+	// slide the idle timeout once less than half of it remains, so the
+	// cookie isn't re-issued on every request
+	now := time.Now()
+	if self.SessionIdleTimeout > 0 && expires.Sub(now) < self.SessionIdleTimeout/2 {
+		if exp := self.sessionExpiry(created, now); exp.After(expires) {
+			expires = exp
+			reissue = true
+		}
+	}
+
+	cookie, _ := r.Cookie(self.cookieName(self.CookieName))
+	if codec, ok := self.codec().(RotatingCodec); ok && self.CookieReissue {
+		if codec.Stale(cookie.Value) {
+			reissue = true
+		}
+	}
+
+	// re-sign a cookie that was signed without the cookie name
+	if _, _, err := self.codec().Decode(cookie.Name, cookie.Value); err != nil {
+		reissue = true
+	}
+
+	if reissue {
+		// re-encode the user data, upgrading cookies written in a
+		// previous format
+		if self.SessionStore == nil {
+			data, _ = encodeUserCookie(u, created)
+		}
+		self.writeCookie(w, self.newUserCookie(), data, expires)
+	}
+	return u, nil
+}
+
+// maxLifetime returns the absolute session timeout, defaulting to the
+// CookieExp.
+func (self *AuthConfig) maxLifetime() time.Duration {
+	if self.SessionMaxLifetime > 0 {
+		return self.SessionMaxLifetime
+	}
+	return self.CookieExp
+}
+
+// sessionExpiry returns the expiration time of a session that was created
+// at the specified time, and last used at the specified time. The session
+// expires after the idle timeout, but never after the absolute timeout.
+func (self *AuthConfig) sessionExpiry(created, accessed time.Time) time.Time {
+	exp := created.Add(self.maxLifetime())
+	if self.SessionIdleTimeout > 0 {
+		if idle := accessed.Add(self.SessionIdleTimeout); idle.Before(exp) {
+			return idle
+		}
+	}
+	return exp
+}
+
+// Versions of the session cookie payload. The payload of cookies written
+// before versioning was added starts with a quote, and is parsed as the
+// legacy %q|%q|... format.
+const (
+	cookieVersion1 = 1
+)
+
+// A DataUser is a User that carries custom data, which is stored in the
+// session along with the User fields. Users returned by GetUserCookie
+// implement DataUser.
+type DataUser interface {
+	User
+	Data() map[string]string
+}
+
+// NewDataUser returns a copy of the User that carries the custom data, so
+// the data is stored in the session by SetUserCookie.
+func NewDataUser(u User, data map[string]string) DataUser {
+	return &user{
+		id:       u.Id(),
+		provider: u.Provider(),
+		name:     u.Name(),
+		email:    u.Email(),
+		org:      u.Org(),
+		link:     u.Link(),
+		picture:  u.Picture(),
+		data:     data,
+	}
+}
+
+// cookiePayload is the JSON representation of a User in the session cookie.
+// Fields are omitted when empty to keep the cookie small, and new fields
+// must be optional so that existing cookies can still be parsed.
+type cookiePayload struct {
+	Id       string            `json:"id"`
+	Provider string            `json:"p,omitempty"`
+	Name     string            `json:"n,omitempty"`
+	Email    string            `json:"e,omitempty"`
+	Org      string            `json:"o,omitempty"`
+	Link     string            `json:"l,omitempty"`
+	Picture  string            `json:"pic,omitempty"`
+	Created  int64             `json:"iat"`
+	Data     map[string]string `json:"d,omitempty"`
+}
+
+// encodeUserCookie returns the session cookie payload for the User, which
+// is a version byte followed by the JSON encoded User.
+func encodeUserCookie(u User, created time.Time) (string, error) {
+	payload := cookiePayload{
+		Id:       u.Id(),
+		Provider: u.Provider(),
+		Name:     u.Name(),
+		Email:    u.Email(),
+		Org:      u.Org(),
+		Link:     u.Link(),
+		Picture:  u.Picture(),
+		Created:  created.Unix(),
+	}
+	if du, ok := u.(DataUser); ok {
+		payload.Data = du.Data()
+	}
+
+	data, err := json.Marshal(&payload)
+	if err != nil {
+		return "", err
+	}
+	return string([]byte{cookieVersion1}) + string(data), nil
+}
+
+// decodeUserCookie parses the User and login time from the session cookie
+// payload, accepting both the versioned and the legacy format.
+func (self *AuthConfig) decodeUserCookie(data string, expires time.Time) (*user, time.Time, error) {
+	if len(data) == 0 {
+		return nil, time.Time{}, ErrInvalidCookieFormat
+	}
+
+	switch data[0] {
+	case cookieVersion1:
+		payload := cookiePayload{}
+		if err := json.Unmarshal([]byte(data[1:]), &payload); err != nil {
+			return nil, time.Time{}, ErrInvalidCookieFormat
+		}
+		u := user{
+			id:       payload.Id,
+			provider: payload.Provider,
+			name:     payload.Name,
+			email:    payload.Email,
+			org:      payload.Org,
+			link:     payload.Link,
+			picture:  payload.Picture,
+			data:     payload.Data,
+		}
+		return &u, time.Unix(payload.Created, 0), nil
+
+	case '"':
+		return self.decodeLegacyUserCookie(data, expires)
+	}
+	return nil, time.Time{}, ErrInvalidCookieFormat
+}
+
+// decodeLegacyUserCookie parses a session cookie payload written in the
+// %q|%q|%q|%q|%q|%q|%q format, which may be followed by the login time.
+func (self *AuthConfig) decodeLegacyUserCookie(data string, expires time.Time) (*user, time.Time, error) {
 	u := user { }
-	_, err = fmt.Fscanf(strings.NewReader(login), "%q|%q|%q|%q|%q|%q|%q",
+	reader := strings.NewReader(data)
+	_, err := fmt.Fscanf(reader, "%q|%q|%q|%q|%q|%q|%q",
 								&u.id, &u.provider, &u.name, &u.email,
 								&u.link, &u.picture, &u.org)
 
 	// if we were unable to parse the cookie return an exception
 	if err != nil {
-		return nil, ErrInvalidCookieFormat
-	}	
+		return nil, time.Time{}, ErrInvalidCookieFormat
+	}
 
-	return &u, err
+	// cookies written before the login time was added are assumed to have
+	// been created with the default expiration
+	var created int64
+	if _, err := fmt.Fscanf(reader, "|%d", &created); err != nil {
+		return &u, expires.Add(-self.CookieExp), nil
+	}
+	return &u, time.Unix(created, 0), nil
 }
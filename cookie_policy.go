@@ -0,0 +1,120 @@
+package auth
+
+import (
+	"net/http"
+	"strings"
+	"time"
+)
+
+// Cookie name prefixes, which instruct the browser to enforce the cookie's
+// attributes.
+//
+// See https://datatracker.ietf.org/doc/html/draft-ietf-httpbis-rfc6265bis#section-4.1.3
+const (
+	// The cookie must be Secure, have a Path of "/" and no Domain, so
+	// it cannot be set or overwritten by a subdomain.
+	CookiePrefixHost = "__Host-"
+
+	// The cookie must be Secure.
+	CookiePrefixSecure = "__Secure-"
+)
+
+// CookiePolicy holds the attributes of every cookie written by this package,
+// including the session cookie, the OAuth2 state cookies and the OAuth1
+// request token cookie. The Secure and HttpOnly attributes are taken from
+// Config.CookieSecure and Config.CookieHttpOnly.
+type CookiePolicy struct {
+
+	// Prefix is prepended to the cookie names, and must be empty,
+	// CookiePrefixHost or CookiePrefixSecure. The Secure attribute is
+	// always set when a Prefix is used.
+	Prefix string
+
+	// Domain of the cookies. If empty, the cookies are only sent to the
+	// host that set them. Ignored when Prefix is CookiePrefixHost.
+	Domain string
+
+	// Path of the cookies. If empty, "/" is used. Ignored when Prefix is
+	// CookiePrefixHost.
+	Path string
+
+	// SameSite mode of the cookies. Note that SameSiteStrictMode prevents
+	// the session cookie from being sent when the user follows a link from
+	// another site, and both SameSiteLaxMode and SameSiteStrictMode prevent
+	// the state cookie from being sent to an OpenId or OAuth callback that
+	// is requested using a cross-site POST.
+	SameSite http.SameSite
+
+	// Partitioned stores the cookies in partitioned (CHIPS) storage, for
+	// applications embedded in a third-party iframe. Requires SameSite to
+	// be http.SameSiteNoneMode.
+	Partitioned bool
+}
+
+// cookieName returns the name of the cookie, including the policy's Prefix.
+// A name that already includes the Prefix is returned unchanged.
+func (self *AuthConfig) cookieName(name string) string {
+	prefix := self.CookiePolicy.Prefix
+	if len(prefix) == 0 || strings.HasPrefix(name, prefix) {
+		return name
+	}
+	return prefix + name
+}
+
+// newCookie returns a cookie with the specified name, and the attributes
+// of the CookiePolicy.
+func (self *AuthConfig) newCookie(name string) *http.Cookie {
+	policy := self.CookiePolicy
+	cookie := &http.Cookie{
+		Name:     self.cookieName(name),
+		Path:     policy.Path,
+		Domain:   policy.Domain,
+		HttpOnly: self.CookieHttpOnly,
+		Secure:   self.CookieSecure,
+		SameSite: policy.SameSite,
+	}
+	if len(cookie.Path) == 0 {
+		cookie.Path = "/"
+	}
+
+	switch policy.Prefix {
+	case CookiePrefixHost:
+		cookie.Path = "/"
+		cookie.Domain = ""
+		cookie.Secure = true
+	case CookiePrefixSecure:
+		cookie.Secure = true
+	}
+
+	// browsers reject SameSite=None and Partitioned cookies that are
+	// not Secure
+	if cookie.SameSite == http.SameSiteNoneMode || policy.Partitioned {
+		cookie.Secure = true
+	}
+	return cookie
+}
+
+// deleteCookie removes the cookie with the specified name. The deletion
+// cookie has the same attributes as the cookie it deletes, otherwise the
+// browser would keep the original cookie.
+func (self *AuthConfig) deleteCookie(w http.ResponseWriter, name string) {
+	cookie := self.newCookie(name)
+	cookie.Value = "deleted"
+	cookie.MaxAge = -1
+	cookie.Expires = time.Unix(1, 0)
+	self.setCookie(w, cookie)
+}
+
+// setCookie adds the Set-Cookie header for the cookie. The Partitioned
+// attribute is appended to the header directly, since http.Cookie only
+// supports it as of Go 1.23.
+func (self *AuthConfig) setCookie(w http.ResponseWriter, cookie *http.Cookie) {
+	value := cookie.String()
+	if len(value) == 0 {
+		return
+	}
+	if self.CookiePolicy.Partitioned {
+		value += "; Partitioned"
+	}
+	w.Header().Add("Set-Cookie", value)
+}
@@ -0,0 +1,120 @@
+package auth
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// Test the ability to store custom data in the session cookie.
+func TestUserCookieData(t *testing.T) {
+	u := NewDataUser(&user{id: "jdoe", email: "jdoe@example.com"}, map[string]string{"tenant": "acme"})
+
+	r, _ := http.NewRequest("GET", "/", nil)
+	w := httptest.NewRecorder()
+	if err := SetUserCookie(w, r, u); err != nil {
+		t.Fatalf("Expected cookie set, got Error %s", err.Error())
+	}
+	r.AddCookie(w.Result().Cookies()[0])
+
+	got, err := GetUserCookie(r)
+	if err != nil {
+		t.Fatalf("Expected User, got Error %s", err.Error())
+	}
+	if got.Email() != "jdoe@example.com" {
+		t.Errorf("Expected jdoe@example.com, got %s", got.Email())
+	}
+	if data := got.(DataUser).Data(); data["tenant"] != "acme" {
+		t.Errorf("Expected tenant acme, got %v", data)
+	}
+}
+
+// Test the ability to accept a cookie in the legacy format, and upgrade it
+// when the cookie is re-issued.
+func TestLegacyUserCookie(t *testing.T) {
+	t.Parallel()
+	config := NewConfig()
+	config.CookieSecret = []byte("7H9xiimk2QdTdYI7rDddfJeV")
+	config.SessionIdleTimeout = time.Hour
+	config.SessionMaxLifetime = config.CookieExp + time.Hour
+
+	legacy := `"jdoe"|"github"|"John Doe"|"jdoe@example.com"|""|""|""`
+	value, _ := config.codec().Encode(config.CookieName, legacy, time.Now().Add(time.Minute))
+
+	r, _ := http.NewRequest("GET", "/", nil)
+	r.AddCookie(&http.Cookie{Name: config.CookieName, Value: value})
+	w := httptest.NewRecorder()
+
+	u, err := config.getSessionUser(w, r)
+	if err != nil {
+		t.Fatalf("Expected legacy cookie accepted, got Error %s", err.Error())
+	}
+	if u.Id() != "jdoe" || u.Name() != "John Doe" {
+		t.Errorf("Expected jdoe (John Doe), got %s (%s)", u.Id(), u.Name())
+	}
+
+	// the cookie is close to the idle timeout, so it is re-issued
+	cookies := w.Result().Cookies()
+	if len(cookies) == 0 {
+		t.Fatalf("Expected cookie re-issued")
+	}
+	data, _, _ := config.codec().Decode(cookies[0].Name, cookies[0].Value)
+	if data[0] != cookieVersion1 {
+		t.Errorf("Expected cookie upgraded to version %d, got %q", cookieVersion1, data)
+	}
+}
+
+// Test the ability to apply the CookiePolicy to the session cookie, and to
+// delete the cookie using the same attributes.
+func TestCookiePolicy(t *testing.T) {
+	policy := Config.CookiePolicy
+	Config.CookiePolicy = CookiePolicy{Prefix: CookiePrefixHost, Domain: "example.com", SameSite: http.SameSiteStrictMode}
+	defer func() { Config.CookiePolicy = policy }()
+
+	r, _ := http.NewRequest("GET", "/", nil)
+	w := httptest.NewRecorder()
+	SetUserCookie(w, r, &user{id: "jdoe"})
+	cookie := w.Result().Cookies()[0]
+
+	if cookie.Name != "__Host-_sess" {
+		t.Errorf("Expected cookie name __Host-_sess, got %s", cookie.Name)
+	}
+	if !cookie.Secure || cookie.Path != "/" || len(cookie.Domain) != 0 {
+		t.Errorf("Expected __Host- cookie to be Secure with Path / and no Domain, got %s", cookie.String())
+	}
+	if cookie.SameSite != http.SameSiteStrictMode {
+		t.Errorf("Expected SameSite=Strict, got %s", cookie.String())
+	}
+
+	r.AddCookie(cookie)
+	if _, err := GetUserCookie(r); err != nil {
+		t.Errorf("Expected User from prefixed cookie, got Error %s", err.Error())
+	}
+
+	w = httptest.NewRecorder()
+	DeleteUserCookie(w, r)
+	deleted := w.Result().Cookies()[0]
+	if deleted.Name != cookie.Name || deleted.Path != cookie.Path || deleted.Secure != cookie.Secure ||
+		deleted.SameSite != cookie.SameSite || deleted.MaxAge != -1 {
+		t.Errorf("Expected deletion cookie to match %s, got %s", cookie.String(), deleted.String())
+	}
+}
+
+// Test the ability to set the Partitioned attribute, which also requires
+// the cookie to be Secure.
+func TestCookiePolicyPartitioned(t *testing.T) {
+	policy := Config.CookiePolicy
+	Config.CookiePolicy = CookiePolicy{SameSite: http.SameSiteNoneMode, Partitioned: true}
+	defer func() { Config.CookiePolicy = policy }()
+
+	r, _ := http.NewRequest("GET", "/", nil)
+	w := httptest.NewRecorder()
+	SetUserCookie(w, r, &user{id: "jdoe"})
+
+	header := w.Header().Get("Set-Cookie")
+	if !strings.HasSuffix(header, "; Partitioned") || !strings.Contains(header, "; Secure") {
+		t.Errorf("Expected Secure and Partitioned cookie, got %s", header)
+	}
+}
@@ -0,0 +1,423 @@
+package auth
+
+import (
+	"crypto/hmac"
+	"crypto/md5"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Error messages related to Digest authentication
+var (
+	ErrStaleNonce         = errors.New("Digest nonce is stale")
+	ErrInvalidDigest      = errors.New("Digest authorization is malformed")
+	ErrNonceCountReplayed = errors.New("Digest nonce count was already used")
+)
+
+// Digest algorithms supported by the DigestAuthProvider.
+const (
+	DigestSHA256 = "SHA-256"
+	DigestMD5    = "MD5"
+)
+
+// A DigestCredentialStore provides the hashed credentials used to verify
+// Digest authentication. Passwords are not stored in plain text, instead
+// HA1 returns the hex encoded hash of "username:realm:password", using the
+// specified algorithm.
+type DigestCredentialStore interface {
+
+	// HA1 returns the hashed credentials for the username, or false if
+	// the user does not exist or has no credentials for the algorithm.
+	HA1(username, realm, algorithm string) (string, bool)
+}
+
+// DigestAlgorithmStore is implemented by a DigestCredentialStore that only
+// holds credentials for some of the algorithms, such as an htdigest file.
+// The DigestAuthProvider only offers the algorithms returned by Algorithms,
+// otherwise a client may answer a challenge the store can't verify.
+type DigestAlgorithmStore interface {
+	Algorithms() []string
+}
+
+// DigestCredentialStoreFunc is an adapter that allows an ordinary function
+// to be used as a DigestCredentialStore.
+type DigestCredentialStoreFunc func(username, realm, algorithm string) (string, bool)
+
+func (f DigestCredentialStoreFunc) HA1(username, realm, algorithm string) (string, bool) {
+	return f(username, realm, algorithm)
+}
+
+// DigestAuthProvider is an implementation of HTTP Digest authentication,
+// for clients that do not support other methods. Only qop=auth is
+// supported.
+// See https://tools.ietf.org/html/rfc7616
+type DigestAuthProvider struct {
+	Realm string
+	Store DigestCredentialStore
+
+	// Algorithms offered to the client, in order of preference.
+	Algorithms []string
+
+	// NonceExp is the amount of time a nonce can be used before the
+	// client must retry with a new nonce.
+	NonceExp time.Duration
+
+	// nonces are signed with the key, so they do not need to be recorded
+	// until they are used with valid credentials
+	once   sync.Once
+	key    []byte
+	opaque string
+
+	sync.Mutex
+	nonces map[string]*digestNonce
+	pruned time.Time
+}
+
+// digestNonceWindow is the number of nonce counts, below the highest count
+// used with a nonce, that are remembered. Lower counts are rejected.
+const digestNonceWindow = 64
+
+// digestNonce records a nonce used with valid credentials, and the nonce
+// counts used with it, to prevent replay attacks. Requests made in parallel
+// may arrive out of order, so each count in the window below the highest
+// count is tracked.
+//
+// See https://tools.ietf.org/html/rfc7616#section-5.12
+type digestNonce struct {
+	issued time.Time
+	max    uint64
+	window uint64 // bit i is set if the count max-i was used
+}
+
+// use records the nonce count, returning false if it was already used or
+// is too old to be tracked.
+func (n *digestNonce) use(count uint64) bool {
+	switch {
+	case count > n.max:
+		if shift := count - n.max; shift < digestNonceWindow {
+			n.window <<= shift
+		} else {
+			n.window = 0
+		}
+		n.window |= 1
+		n.max = count
+		return true
+	case n.max-count >= digestNonceWindow:
+		return false
+	}
+	bit := uint64(1) << (n.max - count)
+	if n.window&bit != 0 {
+		return false
+	}
+	n.window |= bit
+	return true
+}
+
+// NewDigestAuthProvider allocates and returns a new DigestAuthProvider,
+// offering the SHA-256 and MD5 algorithms.
+func NewDigestAuthProvider(realm string, store DigestCredentialStore) *DigestAuthProvider {
+	return &DigestAuthProvider{
+		Realm:      realm,
+		Store:      store,
+		Algorithms: []string{DigestSHA256, DigestMD5},
+		NonceExp:   time.Minute * 5,
+	}
+}
+
+// RedirectRequired returns a boolean value indicating if the request does
+// not include Digest credentials, and the client must be challenged.
+func (self *DigestAuthProvider) RedirectRequired(r *http.Request) bool {
+	scheme, _, _ := strings.Cut(r.Header.Get("Authorization"), " ")
+	return !strings.EqualFold(scheme, "Digest")
+}
+
+// Redirect will challenge the client to send Digest credentials.
+func (self *DigestAuthProvider) Redirect(w http.ResponseWriter, r *http.Request) {
+	self.challenge(w, false)
+	http.Error(w, ErrAuthRequired.Error(), http.StatusUnauthorized)
+}
+
+// GetAuthenticatedUser will verify the Digest credentials, and return the
+// User. If the credentials are invalid, or the nonce is stale, the client is
+// challenged again.
+func (self *DigestAuthProvider) GetAuthenticatedUser(w http.ResponseWriter, r *http.Request) (User, Token, error) {
+	username, err := self.verify(r)
+	if err != nil {
+		self.challenge(w, err == ErrStaleNonce)
+		return nil, nil, err
+	}
+
+	u := user{
+		id:       username,
+		provider: "digest",
+		name:     username,
+	}
+	return &u, nil, nil
+}
+
+// verify checks the Digest credentials of the request, returning the
+// username.
+func (self *DigestAuthProvider) verify(r *http.Request) (string, error) {
+	_, header, _ := strings.Cut(r.Header.Get("Authorization"), " ")
+	params := parseDigestParams(header)
+
+	username := params["username"]
+	algorithm := params["algorithm"]
+	if len(algorithm) == 0 {
+		algorithm = DigestMD5
+	}
+	if len(username) == 0 || params["realm"] != self.Realm || params["qop"] != "auth" ||
+		len(params["nonce"]) == 0 || len(params["cnonce"]) == 0 || !self.offers(algorithm) {
+		return "", ErrInvalidDigest
+	}
+
+	// the uri must be the request target, so credentials can't be used
+	// for a different resource
+	if params["uri"] != r.RequestURI && params["uri"] != r.URL.RequestURI() {
+		return "", ErrInvalidDigest
+	}
+	nc, err := strconv.ParseUint(params["nc"], 16, 64)
+	if err != nil || nc == 0 {
+		return "", ErrInvalidDigest
+	}
+
+	ha1, ok := self.Store.HA1(username, self.Realm, algorithm)
+	if !ok {
+		return "", ErrInvalidCredentials
+	}
+	h := digestHash(algorithm)
+	ha2 := h(r.Method + ":" + params["uri"])
+	expected := h(strings.Join([]string{ha1, params["nonce"], params["nc"], params["cnonce"], "auth", ha2}, ":"))
+	if subtle.ConstantTimeCompare([]byte(expected), []byte(strings.ToLower(params["response"]))) != 1 {
+		return "", ErrInvalidCredentials
+	}
+
+	// the nonce is only checked once the credentials are verified, so
+	// a client is only told the nonce is stale if the password is correct
+	if err := self.useNonce(params["nonce"], nc); err != nil {
+		return "", err
+	}
+	return username, nil
+}
+
+// challenge sets a WWW-Authenticate header for each of the algorithms,
+// with a new nonce.
+func (self *DigestAuthProvider) challenge(w http.ResponseWriter, stale bool) {
+	nonce := self.newNonce()
+	for _, algorithm := range self.algorithms() {
+		challenge := fmt.Sprintf(`Digest realm=%q, qop="auth", algorithm=%s, nonce=%q, opaque=%q`,
+			self.Realm, algorithm, nonce, self.opaque)
+		if stale {
+			challenge += ", stale=true"
+		}
+		w.Header().Add("WWW-Authenticate", challenge)
+	}
+}
+
+// algorithms returns the Algorithms, or SHA-256 and MD5 if none are set,
+// that are supported by the Store.
+func (self *DigestAuthProvider) algorithms() []string {
+	algorithms := self.Algorithms
+	if len(algorithms) == 0 {
+		algorithms = []string{DigestSHA256, DigestMD5}
+	}
+	store, ok := self.Store.(DigestAlgorithmStore)
+	if !ok {
+		return algorithms
+	}
+
+	supported := []string{}
+	for _, a := range algorithms {
+		for _, b := range store.Algorithms() {
+			if strings.EqualFold(a, b) {
+				supported = append(supported, a)
+				break
+			}
+		}
+	}
+	return supported
+}
+
+// nonceExp returns the NonceExp, or 5 minutes if it is not set.
+func (self *DigestAuthProvider) nonceExp() time.Duration {
+	if self.NonceExp == 0 {
+		return time.Minute * 5
+	}
+	return self.NonceExp
+}
+
+// offers returns true if the algorithm is one of the Algorithms.
+func (self *DigestAuthProvider) offers(algorithm string) bool {
+	for _, a := range self.algorithms() {
+		if strings.EqualFold(a, algorithm) {
+			return true
+		}
+	}
+	return false
+}
+
+// init creates the key used to sign nonces, and the opaque value.
+func (self *DigestAuthProvider) init() {
+	self.once.Do(func() {
+		self.key = make([]byte, 32)
+		if _, err := rand.Read(self.key); err != nil {
+			panic(err)
+		}
+		self.opaque = randomString(16)
+	})
+}
+
+// newNonce issues a new nonce, holding the time it was issued and a random
+// value, signed with the server's key.
+func (self *DigestAuthProvider) newNonce() string {
+	self.init()
+	data := make([]byte, 16)
+	binary.BigEndian.PutUint64(data, uint64(time.Now().UnixNano()))
+	if _, err := rand.Read(data[8:]); err != nil {
+		panic(err)
+	}
+	return base64.RawURLEncoding.EncodeToString(append(data, self.sign(data)...))
+}
+
+// sign returns the truncated HMAC of the nonce data.
+func (self *DigestAuthProvider) sign(data []byte) []byte {
+	mac := hmac.New(sha256.New, self.key)
+	mac.Write(data)
+	return mac.Sum(nil)[:16]
+}
+
+// useNonce verifies the nonce was issued by the server and has not expired,
+// and that the nonce count was not used before. It is only called once the
+// credentials are verified, so only authenticated clients are recorded.
+func (self *DigestAuthProvider) useNonce(nonce string, count uint64) error {
+	self.init()
+
+	// a nonce that can't be verified may have been issued before a
+	// restart, the client can retry with a new nonce without prompting
+	// the user
+	raw, err := base64.RawURLEncoding.DecodeString(nonce)
+	if err != nil || len(raw) != 32 || !hmac.Equal(raw[16:], self.sign(raw[:16])) {
+		return ErrStaleNonce
+	}
+	issued := time.Unix(0, int64(binary.BigEndian.Uint64(raw)))
+	now := time.Now()
+	if now.Sub(issued) > self.nonceExp() {
+		return ErrStaleNonce
+	}
+
+	self.Lock()
+	defer self.Unlock()
+
+	//remove nonces that have expired, and can no longer be used
+	if self.nonces == nil {
+		self.nonces = map[string]*digestNonce{}
+	}
+	if now.Sub(self.pruned) > self.nonceExp() {
+		for key, n := range self.nonces {
+			if now.Sub(n.issued) > self.nonceExp() {
+				delete(self.nonces, key)
+			}
+		}
+		self.pruned = now
+	}
+
+	n, ok := self.nonces[nonce]
+	if !ok {
+		n = &digestNonce{issued: issued}
+		self.nonces[nonce] = n
+	}
+	if !n.use(count) {
+		return ErrNonceCountReplayed
+	}
+	return nil
+}
+
+// SecureDigest will attempt to verify the request's Digest credentials prior
+// to executing the auth.SecureHandlerFunc function. The User is also added
+// to the request's context. If the credentials are missing or invalid, the
+// client is challenged with a 401 response.
+func SecureDigest(p *DigestAuthProvider, handler SecureHandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		secureChallenge(defaultAuthenticator(), p, handler)(w, r)
+	}
+}
+
+// SecureDigest will attempt to verify the request's Digest credentials prior
+// to executing the auth.SecureHandlerFunc function, using the Authenticator.
+func (self *Authenticator) SecureDigest(p *DigestAuthProvider, handler SecureHandlerFunc) http.HandlerFunc {
+	return secureChallenge(self, p, handler)
+}
+
+// DigestHA1 returns the hex encoded hash of "username:realm:password", for
+// use by a DigestCredentialStore.
+func DigestHA1(algorithm, username, realm, password string) string {
+	return digestHash(algorithm)(username + ":" + realm + ":" + password)
+}
+
+// digestHash returns a func that hex encodes the hash of a string, using
+// the algorithm.
+func digestHash(algorithm string) func(string) string {
+	newHash := md5.New
+	if strings.EqualFold(algorithm, DigestSHA256) {
+		newHash = sha256.New
+	}
+	return func(s string) string {
+		var h hash.Hash = newHash()
+		h.Write([]byte(s))
+		return hex.EncodeToString(h.Sum(nil))
+	}
+}
+
+// parseDigestParams parses the comma separated auth-params of a Digest
+// Authorization header. Values may be quoted strings.
+func parseDigestParams(header string) map[string]string {
+	params := map[string]string{}
+	for len(header) > 0 {
+		header = strings.TrimLeft(header, " \t,")
+		eq := strings.Index(header, "=")
+		if eq < 0 {
+			break
+		}
+		key := strings.ToLower(strings.TrimSpace(header[:eq]))
+		header = strings.TrimLeft(header[eq+1:], " \t")
+
+		var value string
+		if strings.HasPrefix(header, `"`) {
+			// read the quoted string, handling escaped characters
+			var b strings.Builder
+			i := 1
+			for ; i < len(header) && header[i] != '"'; i++ {
+				if header[i] == '\\' && i+1 < len(header) {
+					i++
+				}
+				b.WriteByte(header[i])
+			}
+			value = b.String()
+			if i < len(header) {
+				i++
+			}
+			header = header[i:]
+		} else {
+			end := strings.Index(header, ",")
+			if end < 0 {
+				end = len(header)
+			}
+			value = strings.TrimSpace(header[:end])
+			header = header[end:]
+		}
+		params[key] = value
+	}
+	return params
+}
@@ -0,0 +1,126 @@
+package auth
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// digestAuthorization returns the Authorization header a client would send
+// in response to the challenge.
+func digestAuthorization(challenge, method, uri, username, password, nc string) string {
+	params := parseDigestParams(challenge[len("Digest "):])
+	h := digestHash(params["algorithm"])
+	ha1 := DigestHA1(params["algorithm"], username, params["realm"], password)
+	ha2 := h(method + ":" + uri)
+	response := h(ha1 + ":" + params["nonce"] + ":" + nc + ":0a4f113b:auth:" + ha2)
+	return fmt.Sprintf(`Digest username=%q, realm=%q, uri=%q, algorithm=%s, nonce=%q, nc=%s, cnonce="0a4f113b", qop=auth, response=%q, opaque=%q`,
+		username, params["realm"], uri, params["algorithm"], params["nonce"], nc, response, params["opaque"])
+}
+
+// Test the ability to authenticate a client using Digest credentials, and
+// to reject replayed and stale nonces.
+func TestSecureDigest(t *testing.T) {
+	path := filepath.Join(t.TempDir(), ".htdigest")
+	os.WriteFile(path, []byte("deploy:tools:"+DigestHA1(DigestMD5, "deploy", "tools", "secret")+"\n"), 0600)
+	file, err := NewHtdigestFile(path)
+	if err != nil {
+		t.Fatalf("Expected htdigest file loaded, got Error %s", err.Error())
+	}
+
+	provider := NewDigestAuthProvider("tools", file)
+	handler := SecureDigest(provider, func(w http.ResponseWriter, r *http.Request, u User) {
+		w.Write([]byte(u.Id()))
+	})
+
+	// the htdigest file only holds MD5 credentials, so SHA-256 is not
+	// offered
+	r, _ := http.NewRequest("GET", "/deploy?env=prod", nil)
+	w := httptest.NewRecorder()
+	handler(w, r)
+	challenges := w.Header()["Www-Authenticate"]
+	if w.Code != http.StatusUnauthorized || len(challenges) != 1 || !strings.Contains(challenges[0], "algorithm=MD5") {
+		t.Fatalf("Expected 401 with an MD5 challenge, got %d %q", w.Code, challenges)
+	}
+
+	r.Header.Set("Authorization", digestAuthorization(challenges[0], "GET", "/deploy?env=prod", "deploy", "secret", "00000001"))
+	w = httptest.NewRecorder()
+	handler(w, r)
+	if w.Code != http.StatusOK || w.Body.String() != "deploy" {
+		t.Errorf("Expected User deploy, got %d %q", w.Code, w.Body.String())
+	}
+
+	// the same nonce count can't be used twice
+	w = httptest.NewRecorder()
+	handler(w, r)
+	if w.Code != http.StatusUnauthorized {
+		t.Errorf("Expected 401 for a replayed nonce count, got %d", w.Code)
+	}
+
+	// requests made in parallel may use the nonce counts out of order
+	for _, test := range []struct {
+		nc   string
+		code int
+	}{{"00000005", http.StatusOK}, {"00000004", http.StatusOK}, {"00000004", http.StatusUnauthorized}} {
+		r.Header.Set("Authorization", digestAuthorization(challenges[0], "GET", "/deploy?env=prod", "deploy", "secret", test.nc))
+		w = httptest.NewRecorder()
+		handler(w, r)
+		if w.Code != test.code {
+			t.Errorf("Expected %d for nonce count %s, got %d", test.code, test.nc, w.Code)
+		}
+	}
+
+	// a nonce that was not issued by the server is stale
+	forged := strings.Replace(challenges[0], parseDigestParams(challenges[0][len("Digest "):])["nonce"], "AAAA", 1)
+	r.Header.Set("Authorization", digestAuthorization(forged, "GET", "/deploy?env=prod", "deploy", "secret", "00000001"))
+	w = httptest.NewRecorder()
+	handler(w, r)
+	if got := w.Header().Get("WWW-Authenticate"); w.Code != http.StatusUnauthorized || !strings.Contains(got, "stale=true") {
+		t.Errorf("Expected 401 with a stale challenge for a forged nonce, got %d %q", w.Code, got)
+	}
+
+	r.Header.Set("Authorization", digestAuthorization(challenges[0], "GET", "/deploy?env=prod", "deploy", "wrong", "00000006"))
+	w = httptest.NewRecorder()
+	handler(w, r)
+	if w.Code != http.StatusUnauthorized {
+		t.Errorf("Expected 401 for an invalid password, got %d", w.Code)
+	}
+
+	provider.NonceExp = time.Nanosecond
+	r.Header.Set("Authorization", digestAuthorization(challenges[0], "GET", "/deploy?env=prod", "deploy", "secret", "00000007"))
+	w = httptest.NewRecorder()
+	handler(w, r)
+	if got := w.Header().Get("WWW-Authenticate"); w.Code != http.StatusUnauthorized || parseDigestParams(got[len("Digest "):])["stale"] != "true" {
+		t.Errorf("Expected 401 with a stale challenge, got %d %q", w.Code, got)
+	}
+}
+
+// Test the ability to use a DigestAuthProvider that was not allocated with
+// NewDigestAuthProvider, with the SHA-256 algorithm.
+func TestDigestZeroValue(t *testing.T) {
+	provider := &DigestAuthProvider{
+		Realm: "tools",
+		Store: DigestCredentialStoreFunc(func(username, realm, algorithm string) (string, bool) {
+			return DigestHA1(algorithm, username, realm, "secret"), true
+		}),
+	}
+
+	r, _ := http.NewRequest("GET", "/deploy", nil)
+	w := httptest.NewRecorder()
+	provider.Redirect(w, r)
+	challenges := w.Header()["Www-Authenticate"]
+	if len(challenges) != 2 || !strings.Contains(challenges[0], "algorithm=SHA-256") {
+		t.Fatalf("Expected SHA-256 and MD5 challenges, got %q", challenges)
+	}
+	challenge := challenges[0]
+
+	r.Header.Set("Authorization", digestAuthorization(challenge, "GET", "/deploy", "deploy", "secret", "00000001"))
+	if u, _, err := provider.GetAuthenticatedUser(httptest.NewRecorder(), r); err != nil || u.Id() != "deploy" {
+		t.Errorf("Expected User deploy, got %v %v", u, err)
+	}
+}
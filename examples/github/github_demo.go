@@ -44,10 +44,10 @@ var privatepage2 = `
 </html>
 `
 
-// private webpage, authentication required, with User Id injected in the
-// r.URL.User.Username() field
+// private webpage, authentication required, with the User injected in the
+// request context
 func Private1(w http.ResponseWriter, r *http.Request) {
-	user := r.URL.User.Username()
+	user := auth.UserFromContext(r.Context()).Id()
 	fmt.Fprintf(w, fmt.Sprintf(privatepage1, user, user))
 }
 
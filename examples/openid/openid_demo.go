@@ -32,7 +32,7 @@ var privatepage = `
 
 // private webpage, authentication required
 func Private(w http.ResponseWriter, r *http.Request) {
-	user := r.URL.User.Username()
+	user := auth.UserFromContext(r.Context()).Id()
 	fmt.Fprintf(w, fmt.Sprintf(privatepage, user, user))
 }
 
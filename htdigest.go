@@ -0,0 +1,56 @@
+package auth
+
+import (
+	"strings"
+)
+
+// HtdigestFile is a DigestCredentialStore backed by an Apache htdigest file,
+// with lines in the format "username:realm:ha1". The htdigest format only
+// supports the MD5 algorithm. The file is re-loaded when it changes.
+type HtdigestFile struct {
+	Path string
+
+	userFile
+}
+
+// NewHtdigestFile allocates and returns a new HtdigestFile, loading the
+// users from the file at the specified path.
+func NewHtdigestFile(path string) (*HtdigestFile, error) {
+	file := HtdigestFile{Path: path}
+	if err := file.reload(); err != nil {
+		return nil, err
+	}
+	return &file, nil
+}
+
+// Algorithms returns the algorithms the htdigest file holds credentials
+// for, which is only MD5.
+func (self *HtdigestFile) Algorithms() []string {
+	return []string{DigestMD5}
+}
+
+func (self *HtdigestFile) HA1(username, realm, algorithm string) (string, bool) {
+	if !strings.EqualFold(algorithm, DigestMD5) {
+		return "", false
+	}
+
+	self.Lock()
+	defer self.Unlock()
+
+	// a file that can't be read keeps the previously loaded users
+	self.reload()
+	ha1, ok := self.users[username+":"+realm]
+	return ha1, ok
+}
+
+// reload loads the users if the file changed since it was last loaded.
+func (self *HtdigestFile) reload() error {
+	_, err := self.userFile.reload(self.Path, func(line string) (string, string, bool) {
+		parts := strings.Split(line, ":")
+		if len(parts) != 3 {
+			return "", "", false
+		}
+		return parts[0] + ":" + parts[1], strings.ToLower(parts[2]), true
+	})
+	return err
+}
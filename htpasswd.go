@@ -0,0 +1,181 @@
+package auth
+
+import (
+	"crypto/md5"
+	"crypto/sha1"
+	"crypto/subtle"
+	"encoding/base64"
+	"strings"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// HtpasswdFile is a CredentialChecker that verifies passwords against an
+// Apache htpasswd file. The bcrypt, SHA1 ({SHA}) and APR1-MD5 ($apr1$)
+// password formats are supported. The file is re-loaded when it changes.
+type HtpasswdFile struct {
+	Path string
+
+	userFile
+	dummy string
+}
+
+// NewHtpasswdFile allocates and returns a new HtpasswdFile, loading the
+// users from the file at the specified path.
+func NewHtpasswdFile(path string) (*HtpasswdFile, error) {
+	file := HtpasswdFile{Path: path}
+	if err := file.reload(); err != nil {
+		return nil, err
+	}
+	return &file, nil
+}
+
+// Check returns true if the password matches the hash for the username.
+func (self *HtpasswdFile) Check(username, password string) bool {
+	self.Lock()
+	// a file that can't be read keeps the previously loaded users
+	self.reload()
+	hash, ok := self.users[username]
+	dummy := self.dummy
+	self.Unlock()
+
+	if !ok {
+		// compare against the costliest hash in the file, so an unknown
+		// user takes as long to reject as a wrong password
+		checkHtpasswd(dummy, password)
+		return false
+	}
+	return checkHtpasswd(hash, password)
+}
+
+// reload loads the users if the file changed since it was last loaded.
+func (self *HtpasswdFile) reload() error {
+	loaded, err := self.userFile.reload(self.Path, func(line string) (string, string, bool) {
+		return strings.Cut(line, ":")
+	})
+	if loaded {
+		self.dummy = costliestHtpasswd(self.users)
+	}
+	return err
+}
+
+// costliestHtpasswd returns the hash that takes the longest to check, so
+// that comparing an unknown user's password against it takes as long as
+// comparing against any user's hash. Hashes in an unsupported format are
+// rejected immediately, so an empty string is returned if there are none.
+func costliestHtpasswd(users map[string]string) string {
+	dummy, cost := "", 0
+	for _, hash := range users {
+		c := 0
+		switch {
+		case strings.HasPrefix(hash, "$2y$"), strings.HasPrefix(hash, "$2a$"), strings.HasPrefix(hash, "$2b$"):
+			// bcrypt.Cost ranges from 4 to 31, above the other formats
+			c, _ = bcrypt.Cost([]byte(hash))
+		case strings.HasPrefix(hash, "$apr1$"):
+			c = 2
+		case strings.HasPrefix(hash, "{SHA}"):
+			c = 1
+		}
+		if c > cost {
+			dummy, cost = hash, c
+		}
+	}
+	return dummy
+}
+
+// checkHtpasswd returns true if the password matches the htpasswd hash.
+func checkHtpasswd(hash, password string) bool {
+	switch {
+	case strings.HasPrefix(hash, "$2y$"), strings.HasPrefix(hash, "$2a$"), strings.HasPrefix(hash, "$2b$"):
+		return bcrypt.CompareHashAndPassword([]byte(hash), []byte(password)) == nil
+
+	case strings.HasPrefix(hash, "{SHA}"):
+		sum := sha1.Sum([]byte(password))
+		expected := "{SHA}" + base64.StdEncoding.EncodeToString(sum[:])
+		return subtle.ConstantTimeCompare([]byte(hash), []byte(expected)) == 1
+
+	case strings.HasPrefix(hash, "$apr1$"):
+		parts := strings.SplitN(hash[len("$apr1$"):], "$", 2)
+		if len(parts) != 2 {
+			return false
+		}
+		return subtle.ConstantTimeCompare([]byte(hash), []byte(apr1(password, parts[0]))) == 1
+	}
+
+	// crypt(3) and plain text passwords are not supported
+	return false
+}
+
+// apr1 returns the Apache variant of the MD5-based crypt(3) hash.
+//
+// See https://httpd.apache.org/docs/2.4/misc/password_encryptions.html
+func apr1(password, salt string) string {
+	const magic = "$apr1$"
+	if len(salt) > 8 {
+		salt = salt[:8]
+	}
+	pw := []byte(password)
+
+	alt := md5.New()
+	alt.Write(pw)
+	alt.Write([]byte(salt))
+	alt.Write(pw)
+	altSum := alt.Sum(nil)
+
+	ctx := md5.New()
+	ctx.Write(pw)
+	ctx.Write([]byte(magic + salt))
+	for i := len(pw); i > 0; i -= 16 {
+		if i > 16 {
+			ctx.Write(altSum)
+		} else {
+			ctx.Write(altSum[:i])
+		}
+	}
+	for i := len(pw); i > 0; i >>= 1 {
+		if i&1 != 0 {
+			ctx.Write([]byte{0})
+		} else {
+			ctx.Write(pw[:1])
+		}
+	}
+	sum := ctx.Sum(nil)
+
+	// stretch the hash, to slow down brute force attacks
+	for i := 0; i < 1000; i++ {
+		round := md5.New()
+		if i&1 != 0 {
+			round.Write(pw)
+		} else {
+			round.Write(sum)
+		}
+		if i%3 != 0 {
+			round.Write([]byte(salt))
+		}
+		if i%7 != 0 {
+			round.Write(pw)
+		}
+		if i&1 != 0 {
+			round.Write(sum)
+		} else {
+			round.Write(pw)
+		}
+		sum = round.Sum(nil)
+	}
+
+	// encode the hash using the crypt(3) base64 alphabet and byte order
+	const itoa64 = "./0123456789ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz"
+	encoded := make([]byte, 0, 22)
+	encode := func(v uint, n int) {
+		for ; n > 0; n-- {
+			encoded = append(encoded, itoa64[v&0x3f])
+			v >>= 6
+		}
+	}
+	for _, g := range [][3]int{{0, 6, 12}, {1, 7, 13}, {2, 8, 14}, {3, 9, 15}, {4, 10, 5}} {
+		encode(uint(sum[g[0]])<<16|uint(sum[g[1]])<<8|uint(sum[g[2]]), 4)
+	}
+	encode(uint(sum[11]), 2)
+
+	return magic + salt + "$" + string(encoded)
+}
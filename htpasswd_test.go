@@ -0,0 +1,88 @@
+package auth
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// Test the ability to verify passwords in the bcrypt, SHA1 and APR1-MD5
+// htpasswd formats, and to reload the file when it changes.
+func TestHtpasswdFile(t *testing.T) {
+	hash, _ := bcrypt.GenerateFromPassword([]byte("secret"), bcrypt.MinCost)
+	path := filepath.Join(t.TempDir(), ".htpasswd")
+	os.WriteFile(path, []byte("# machine logins\n"+
+		"bcrypt:"+string(hash)+"\n"+
+		"sha:{SHA}5en6G6MezRroT3XKqkdPOmY/BfQ=\n"+
+		"apr:$apr1$r31abcde$kl9eNjSys8oZ/nHjspdaj0\n"), 0600)
+
+	file, err := NewHtpasswdFile(path)
+	if err != nil {
+		t.Fatalf("Expected htpasswd file loaded, got Error %s", err.Error())
+	}
+
+	tests := []struct {
+		username, password string
+		valid              bool
+	}{
+		{"bcrypt", "secret", true},
+		{"bcrypt", "wrong", false},
+		{"sha", "secret", true},
+		{"sha", "wrong", false},
+		{"apr", "myPassword", true},
+		{"apr", "mypassword", false},
+		{"unknown", "secret", false},
+	}
+	for _, test := range tests {
+		if got := file.Check(test.username, test.password); got != test.valid {
+			t.Errorf("Expected Check(%s, %s) %v, got %v", test.username, test.password, test.valid, got)
+		}
+	}
+
+	if file.dummy != string(hash) {
+		t.Errorf("Expected unknown users compared against the bcrypt hash, got %q", file.dummy)
+	}
+
+	os.WriteFile(path, []byte("sha:{SHA}5en6G6MezRroT3XKqkdPOmY/BfQ=\n"), 0600)
+	if file.Check("bcrypt", "secret") {
+		t.Errorf("Expected removed user rejected after the file changed")
+	}
+	if file.dummy != "{SHA}5en6G6MezRroT3XKqkdPOmY/BfQ=" {
+		t.Errorf("Expected unknown users compared against the SHA1 hash, got %q", file.dummy)
+	}
+}
+
+// Test the ability to challenge a client without valid Basic credentials.
+func TestSecureBasic(t *testing.T) {
+	checker := CredentialCheckerFunc(func(username, password string) bool {
+		return username == "deploy" && password == "secret"
+	})
+	handler := SecureBasic("tools", checker, func(w http.ResponseWriter, r *http.Request, u User) {
+		w.Write([]byte(u.Id()))
+	})
+
+	r, _ := http.NewRequest("GET", "/", nil)
+	w := httptest.NewRecorder()
+	handler(w, r)
+	if w.Code != http.StatusUnauthorized || w.Header().Get("WWW-Authenticate") != `Basic realm="tools", charset="UTF-8"` {
+		t.Errorf("Expected 401 with a Basic challenge, got %d %q", w.Code, w.Header().Get("WWW-Authenticate"))
+	}
+
+	r.SetBasicAuth("deploy", "wrong")
+	w = httptest.NewRecorder()
+	handler(w, r)
+	if w.Code != http.StatusUnauthorized {
+		t.Errorf("Expected 401 for invalid credentials, got %d", w.Code)
+	}
+
+	r.SetBasicAuth("deploy", "secret")
+	w = httptest.NewRecorder()
+	handler(w, r)
+	if w.Code != http.StatusOK || w.Body.String() != "deploy" {
+		t.Errorf("Expected User deploy, got %d %q", w.Code, w.Body.String())
+	}
+}
@@ -0,0 +1,346 @@
+package jwt
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ErrUnsupportedKey is returned when a JSON Web Key uses an unsupported
+// key type or curve.
+var ErrUnsupportedKey = errors.New("jwt: unsupported key type")
+
+// Default cache durations used by a RemoteKeySet.
+const (
+	// Used when the key set response has no cache headers.
+	DefaultKeySetTTL = time.Hour
+
+	// Upper bound on the cache duration advertised by the cache headers.
+	MaxKeySetTTL = time.Hour * 24
+
+	// Minimum time between fetches triggered by an unknown kid.
+	DefaultKeySetRefresh = time.Minute
+)
+
+// A KeySource provides the public keys used to verify a JWT.
+type KeySource interface {
+	// Key returns the public key identified by kid.
+	Key(kid string) (crypto.PublicKey, error)
+}
+
+// JSONWebKey represents a public JSON Web Key.
+//
+// See http://tools.ietf.org/html/rfc7517
+type JSONWebKey struct {
+	KeyType   string `json:"kty"`
+	KeyId     string `json:"kid"`
+	Use       string `json:"use"`
+	Algorithm string `json:"alg"`
+
+	// RSA public key parameters
+	N string `json:"n"`
+	E string `json:"e"`
+
+	// EC and OKP public key parameters
+	Curve string `json:"crv"`
+	X     string `json:"x"`
+	Y     string `json:"y"`
+}
+
+// PublicKey returns the public key represented by the JSON Web Key.
+func (k *JSONWebKey) PublicKey() (crypto.PublicKey, error) {
+	switch k.KeyType {
+	case "RSA":
+		n, err := base64.RawURLEncoding.DecodeString(k.N)
+		if err != nil {
+			return nil, err
+		}
+		e, err := base64.RawURLEncoding.DecodeString(k.E)
+		if err != nil {
+			return nil, err
+		}
+		return &rsa.PublicKey{
+			N: new(big.Int).SetBytes(n),
+			E: int(new(big.Int).SetBytes(e).Int64()),
+		}, nil
+
+	case "EC":
+		var curve elliptic.Curve
+		switch k.Curve {
+		case "P-256":
+			curve = elliptic.P256()
+		case "P-384":
+			curve = elliptic.P384()
+		case "P-521":
+			curve = elliptic.P521()
+		default:
+			return nil, ErrUnsupportedKey
+		}
+		x, err := base64.RawURLEncoding.DecodeString(k.X)
+		if err != nil {
+			return nil, err
+		}
+		y, err := base64.RawURLEncoding.DecodeString(k.Y)
+		if err != nil {
+			return nil, err
+		}
+		key := ecdsa.PublicKey{Curve: curve, X: new(big.Int).SetBytes(x), Y: new(big.Int).SetBytes(y)}
+		if !curve.IsOnCurve(key.X, key.Y) {
+			return nil, ErrUnsupportedKey
+		}
+		return &key, nil
+
+	case "OKP":
+		if k.Curve != "Ed25519" {
+			return nil, ErrUnsupportedKey
+		}
+		x, err := base64.RawURLEncoding.DecodeString(k.X)
+		if err != nil || len(x) != ed25519.PublicKeySize {
+			return nil, ErrUnsupportedKey
+		}
+		return ed25519.PublicKey(x), nil
+	}
+	return nil, ErrUnsupportedKey
+}
+
+// KeySet represents a JSON Web Key Set document.
+type KeySet struct {
+	Keys []JSONWebKey `json:"keys"`
+}
+
+// Key returns the signing key identified by kid.
+func (s *KeySet) Key(kid string) (crypto.PublicKey, error) {
+	for _, jwk := range s.Keys {
+		if jwk.KeyId == kid && (len(jwk.Use) == 0 || jwk.Use == "sig") {
+			return jwk.PublicKey()
+		}
+	}
+	return nil, ErrKeyNotFound
+}
+
+// RemoteKeySet is a KeySource that fetches a JSON Web Key Set document
+// from a URL. The keys are cached until the response's cache headers
+// indicate they are stale, and re-fetched when a token is signed with an
+// unknown key.
+type RemoteKeySet struct {
+	URL string
+
+	// Used when the response has no cache headers.
+	DefaultTTL time.Duration
+
+	// Minimum time between fetches triggered by an unknown kid, so that
+	// tokens with random kid values cannot flood the key set URL.
+	MinRefresh time.Duration
+
+	// Client used to fetch the key set. If nil, DefaultKeySetClient is
+	// used.
+	Client *http.Client
+
+	mu       sync.Mutex
+	keys     map[string]crypto.PublicKey
+	fetched  time.Time
+	expires  time.Time
+	inflight *keySetFetch
+}
+
+// DefaultKeySetClient is the http.Client used to fetch a key set, with a
+// timeout so that an unresponsive URL does not stall token verification.
+var DefaultKeySetClient = &http.Client{Timeout: time.Second * 10}
+
+// keySetFetch is a fetch of the key set that is in progress. Callers that
+// need the keys while a fetch is in progress wait for it to complete,
+// instead of fetching the key set again.
+type keySetFetch struct {
+	done chan struct{}
+	err  error
+}
+
+// NewRemoteKeySet allocates and returns a new RemoteKeySet.
+func NewRemoteKeySet(url string) *RemoteKeySet {
+	return &RemoteKeySet{
+		URL:        url,
+		DefaultTTL: DefaultKeySetTTL,
+		MinRefresh: DefaultKeySetRefresh,
+	}
+}
+
+// Key returns the public key identified by kid, fetching the key set if
+// the cached keys are stale or the key is unknown.
+func (s *RemoteKeySet) Key(kid string) (crypto.PublicKey, error) {
+
+	// a stale key set is still used if it cannot be refreshed, or while
+	// it is being refreshed
+	err := s.refresh(false, func(now time.Time) bool {
+		return now.After(s.expires) && (s.keys == nil || now.Sub(s.fetched) >= s.MinRefresh)
+	})
+	key, ok, loaded := s.cached(kid)
+	switch {
+	case ok:
+		return key, nil
+	case !loaded:
+		return nil, err
+	}
+
+	// the key may have been rotated since the key set was fetched
+	err = s.refresh(true, func(now time.Time) bool {
+		return now.Sub(s.fetched) >= s.MinRefresh
+	})
+	if err != nil {
+		return nil, err
+	}
+	if key, ok, _ := s.cached(kid); ok {
+		return key, nil
+	}
+	return nil, ErrKeyNotFound
+}
+
+// cached returns the cached key identified by kid, and whether the key set
+// was loaded.
+func (s *RemoteKeySet) cached(kid string) (crypto.PublicKey, bool, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	key, ok := s.keys[kid]
+	return key, ok, s.keys != nil
+}
+
+// refresh fetches the key set if need, which is called with the lock held,
+// returns true. The key set is fetched without holding the lock, so cached
+// keys can be used while it is fetched, and only one fetch is made at a
+// time. If a fetch is in progress, refresh waits for it if wait is true or
+// no keys are cached, otherwise it returns immediately.
+func (s *RemoteKeySet) refresh(wait bool, need func(now time.Time) bool) error {
+	s.mu.Lock()
+	if f := s.inflight; f != nil {
+		if !wait && s.keys != nil {
+			s.mu.Unlock()
+			return nil
+		}
+		s.mu.Unlock()
+		<-f.done
+		return f.err
+	}
+
+	now := time.Now()
+	if !need(now) {
+		s.mu.Unlock()
+		return nil
+	}
+	f := &keySetFetch{done: make(chan struct{})}
+	s.inflight = f
+	s.fetched = now
+	s.mu.Unlock()
+
+	keys, ttl, err := s.fetch()
+
+	s.mu.Lock()
+	if err == nil {
+		s.keys = keys
+		s.expires = now.Add(ttl)
+	}
+	s.inflight = nil
+	s.mu.Unlock()
+
+	f.err = err
+	close(f.done)
+	return err
+}
+
+// fetch retrieves the key set document, and returns the keys and how long
+// they can be cached.
+func (s *RemoteKeySet) fetch() (map[string]crypto.PublicKey, time.Duration, error) {
+	client := s.Client
+	if client == nil {
+		client = DefaultKeySetClient
+	}
+	resp, err := client.Get(s.URL)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, 0, fmt.Errorf("jwt: unable to fetch key set, status %d", resp.StatusCode)
+	}
+
+	set := KeySet{}
+	if err := json.NewDecoder(resp.Body).Decode(&set); err != nil {
+		return nil, 0, err
+	}
+
+	keys := map[string]crypto.PublicKey{}
+	for _, jwk := range set.Keys {
+		if len(jwk.Use) > 0 && jwk.Use != "sig" {
+			continue
+		}
+		key, err := jwk.PublicKey()
+		if err != nil {
+			continue
+		}
+		keys[jwk.KeyId] = key
+	}
+
+	// the key set is cached for at least MinRefresh, even if the response
+	// must not be cached, to avoid fetching it for every token
+	ttl := cacheTTL(resp.Header, s.DefaultTTL)
+	if ttl < s.MinRefresh {
+		ttl = s.MinRefresh
+	}
+	return keys, ttl, nil
+}
+
+// cacheTTL returns how long a response may be cached, using the
+// Cache-Control max-age directive, or the Expires header.
+func cacheTTL(header http.Header, defaultTTL time.Duration) time.Duration {
+	ttl := defaultTTL
+
+	if cc := header.Get("Cache-Control"); len(cc) > 0 {
+		for _, directive := range strings.Split(cc, ",") {
+			directive = strings.ToLower(strings.TrimSpace(directive))
+			switch {
+			case directive == "no-cache" || directive == "no-store":
+				return 0
+			case strings.HasPrefix(directive, "max-age="):
+				seconds, err := strconv.ParseInt(directive[8:], 10, 64)
+				if err != nil {
+					continue
+				}
+				ttl = time.Duration(seconds) * time.Second
+				if age, err := strconv.ParseInt(header.Get("Age"), 10, 64); err == nil {
+					ttl -= time.Duration(age) * time.Second
+				}
+				return clampTTL(ttl)
+			}
+		}
+	}
+
+	if expires, err := http.ParseTime(header.Get("Expires")); err == nil {
+		date, err := http.ParseTime(header.Get("Date"))
+		if err != nil {
+			date = time.Now()
+		}
+		return clampTTL(expires.Sub(date))
+	}
+	return clampTTL(ttl)
+}
+
+func clampTTL(ttl time.Duration) time.Duration {
+	switch {
+	case ttl < 0:
+		return 0
+	case ttl > MaxKeySetTTL:
+		return MaxKeySetTTL
+	}
+	return ttl
+}
@@ -0,0 +1,261 @@
+// Package jwt parses and verifies JSON Web Tokens signed using
+// JSON Web Signature (JWS).
+//
+// See http://tools.ietf.org/html/rfc7519
+package jwt
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rsa"
+	_ "crypto/sha256"
+	_ "crypto/sha512"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"math/big"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Error messages related to parsing and verifying a JWT
+var (
+	ErrMalformed            = errors.New("jwt: malformed token")
+	ErrUnsupportedAlgorithm = errors.New("jwt: unsupported signing algorithm")
+	ErrInvalidSignature     = errors.New("jwt: invalid signature")
+	ErrKeyNotFound          = errors.New("jwt: signing key not found")
+)
+
+// Header is the JOSE Header of a JWT.
+type Header struct {
+	// The algorithm used to sign the token (ie RS256).
+	Algorithm string `json:"alg"`
+
+	// Identifies the key used to sign the token.
+	KeyId string `json:"kid"`
+
+	// The media type of the token (ie JWT).
+	Type string `json:"typ"`
+}
+
+// Token represents a parsed, but not necessarily verified, JWT.
+type Token struct {
+	// The raw, encoded token.
+	Raw string
+
+	// The decoded JOSE Header.
+	Header Header
+
+	// The decoded JSON claims set.
+	Payload []byte
+
+	signed    string // the signing input (header.payload)
+	signature []byte // the decoded signature
+}
+
+// Parse decodes the compact serialization of a JWT, without verifying
+// the signature.
+func Parse(raw string) (*Token, error) {
+	parts := strings.Split(raw, ".")
+	if len(parts) != 3 {
+		return nil, ErrMalformed
+	}
+
+	token := Token{Raw: raw, signed: parts[0] + "." + parts[1]}
+
+	header, err := base64.RawURLEncoding.DecodeString(parts[0])
+	if err != nil {
+		return nil, ErrMalformed
+	}
+	if err := json.Unmarshal(header, &token.Header); err != nil {
+		return nil, ErrMalformed
+	}
+
+	token.Payload, err = base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil {
+		return nil, ErrMalformed
+	}
+
+	token.signature, err = base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, ErrMalformed
+	}
+
+	return &token, nil
+}
+
+// Verify parses the JWT and verifies the signature using the key
+// identified by the token's kid header.
+func Verify(raw string, keys KeySource) (*Token, error) {
+	token, err := Parse(raw)
+	if err != nil {
+		return nil, err
+	}
+
+	key, err := keys.Key(token.Header.KeyId)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := token.Verify(key); err != nil {
+		return nil, err
+	}
+	return token, nil
+}
+
+// Verify checks the token's signature using the public key. The key type
+// must match the algorithm in the token's header. Symmetric algorithms
+// (ie HS256) and unsigned tokens are never accepted.
+func (t *Token) Verify(key crypto.PublicKey) error {
+	switch t.Header.Algorithm {
+	case "RS256":
+		return verifyRSA(key, crypto.SHA256, t.signed, t.signature)
+	case "RS384":
+		return verifyRSA(key, crypto.SHA384, t.signed, t.signature)
+	case "RS512":
+		return verifyRSA(key, crypto.SHA512, t.signed, t.signature)
+	case "PS256":
+		return verifyPSS(key, crypto.SHA256, t.signed, t.signature)
+	case "PS384":
+		return verifyPSS(key, crypto.SHA384, t.signed, t.signature)
+	case "PS512":
+		return verifyPSS(key, crypto.SHA512, t.signed, t.signature)
+	case "ES256":
+		return verifyECDSA(key, elliptic.P256(), crypto.SHA256, t.signed, t.signature)
+	case "ES384":
+		return verifyECDSA(key, elliptic.P384(), crypto.SHA384, t.signed, t.signature)
+	case "ES512":
+		return verifyECDSA(key, elliptic.P521(), crypto.SHA512, t.signed, t.signature)
+	case "EdDSA":
+		return verifyEdDSA(key, t.signed, t.signature)
+	}
+	return ErrUnsupportedAlgorithm
+}
+
+// Claims unmarshals the token's JSON claims set into v.
+func (t *Token) Claims(v interface{}) error {
+	return json.Unmarshal(t.Payload, v)
+}
+
+func verifyRSA(key crypto.PublicKey, hash crypto.Hash, signed string, signature []byte) error {
+	rsaKey, ok := key.(*rsa.PublicKey)
+	if !ok {
+		return ErrInvalidSignature
+	}
+
+	h := hash.New()
+	h.Write([]byte(signed))
+	if rsa.VerifyPKCS1v15(rsaKey, hash, h.Sum(nil), signature) != nil {
+		return ErrInvalidSignature
+	}
+	return nil
+}
+
+func verifyPSS(key crypto.PublicKey, hash crypto.Hash, signed string, signature []byte) error {
+	rsaKey, ok := key.(*rsa.PublicKey)
+	if !ok {
+		return ErrInvalidSignature
+	}
+
+	h := hash.New()
+	h.Write([]byte(signed))
+	opts := rsa.PSSOptions{SaltLength: rsa.PSSSaltLengthEqualsHash}
+	if rsa.VerifyPSS(rsaKey, hash, h.Sum(nil), signature, &opts) != nil {
+		return ErrInvalidSignature
+	}
+	return nil
+}
+
+// verifyECDSA verifies a JWS ECDSA signature, which is the concatenation of
+// the fixed-size R and S values rather than an ASN.1 structure.
+func verifyECDSA(key crypto.PublicKey, curve elliptic.Curve, hash crypto.Hash, signed string, signature []byte) error {
+	ecKey, ok := key.(*ecdsa.PublicKey)
+	if !ok || ecKey.Curve != curve {
+		return ErrInvalidSignature
+	}
+
+	size := (curve.Params().BitSize + 7) / 8
+	if len(signature) != 2*size {
+		return ErrInvalidSignature
+	}
+	r := new(big.Int).SetBytes(signature[:size])
+	s := new(big.Int).SetBytes(signature[size:])
+
+	h := hash.New()
+	h.Write([]byte(signed))
+	if !ecdsa.Verify(ecKey, h.Sum(nil), r, s) {
+		return ErrInvalidSignature
+	}
+	return nil
+}
+
+func verifyEdDSA(key crypto.PublicKey, signed string, signature []byte) error {
+	edKey, ok := key.(ed25519.PublicKey)
+	if !ok || len(edKey) != ed25519.PublicKeySize {
+		return ErrInvalidSignature
+	}
+	if !ed25519.Verify(edKey, []byte(signed), signature) {
+		return ErrInvalidSignature
+	}
+	return nil
+}
+
+// Claims represents the registered claims of a JWT.
+type Claims struct {
+	Issuer    string      `json:"iss"`
+	Subject   string      `json:"sub"`
+	Audience  Audience    `json:"aud"`
+	Expiry    NumericDate `json:"exp"`
+	NotBefore NumericDate `json:"nbf"`
+	IssuedAt  NumericDate `json:"iat"`
+	Id        string      `json:"jti"`
+}
+
+// Audience is the aud claim, which may be encoded as a single string or
+// as an array of strings.
+type Audience []string
+
+// Contains returns true if the audience includes the specified value.
+func (a Audience) Contains(value string) bool {
+	for _, aud := range a {
+		if aud == value {
+			return true
+		}
+	}
+	return false
+}
+
+func (a *Audience) UnmarshalJSON(data []byte) error {
+	var single string
+	if err := json.Unmarshal(data, &single); err == nil {
+		*a = Audience{single}
+		return nil
+	}
+	var multiple []string
+	if err := json.Unmarshal(data, &multiple); err != nil {
+		return err
+	}
+	*a = Audience(multiple)
+	return nil
+}
+
+// NumericDate is a JSON numeric value representing the number of seconds
+// since the Unix epoch. The zero value indicates the claim is not present.
+type NumericDate int64
+
+// Time returns the NumericDate as a time.Time.
+func (d NumericDate) Time() time.Time {
+	return time.Unix(int64(d), 0)
+}
+
+func (d *NumericDate) UnmarshalJSON(data []byte) error {
+	value, err := strconv.ParseFloat(string(data), 64)
+	if err != nil {
+		return err
+	}
+	*d = NumericDate(value)
+	return nil
+}
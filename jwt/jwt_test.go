@@ -0,0 +1,238 @@
+package jwt
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// helper function to sign a JWT using the specified algorithm and key.
+func sign(t *testing.T, alg, kid string, key crypto.Signer, claims interface{}) string {
+	header, _ := json.Marshal(Header{Algorithm: alg, KeyId: kid})
+	payload, _ := json.Marshal(claims)
+	signed := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(payload)
+
+	var sig []byte
+	var err error
+	switch alg {
+	case "RS256", "PS256":
+		h := crypto.SHA256.New()
+		h.Write([]byte(signed))
+		if alg == "PS256" {
+			sig, err = key.Sign(rand.Reader, h.Sum(nil), &rsa.PSSOptions{SaltLength: rsa.PSSSaltLengthEqualsHash, Hash: crypto.SHA256})
+		} else {
+			sig, err = key.Sign(rand.Reader, h.Sum(nil), crypto.SHA256)
+		}
+	case "ES256":
+		h := crypto.SHA256.New()
+		h.Write([]byte(signed))
+		r, s, _ := ecdsa.Sign(rand.Reader, key.(*ecdsa.PrivateKey), h.Sum(nil))
+		sig = make([]byte, 64)
+		r.FillBytes(sig[:32])
+		s.FillBytes(sig[32:])
+	case "EdDSA":
+		sig, err = key.Sign(rand.Reader, []byte(signed), crypto.Hash(0))
+	}
+	if err != nil {
+		t.Fatalf("Unable to sign token: %s", err)
+	}
+	return signed + "." + base64.RawURLEncoding.EncodeToString(sig)
+}
+
+// Test the ability to verify tokens signed with each supported key type.
+func TestVerify(t *testing.T) {
+	rsaKey, _ := rsa.GenerateKey(rand.Reader, 2048)
+	ecKey, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	_, edKey, _ := ed25519.GenerateKey(rand.Reader)
+
+	// keys of the same types, that did not sign the tokens
+	otherRSA, _ := rsa.GenerateKey(rand.Reader, 2048)
+	otherEC, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	_, otherEd, _ := ed25519.GenerateKey(rand.Reader)
+
+	tests := []struct {
+		alg   string
+		key   crypto.Signer
+		other crypto.Signer
+	}{
+		{"RS256", rsaKey, otherRSA},
+		{"PS256", rsaKey, otherRSA},
+		{"ES256", ecKey, otherEC},
+		{"EdDSA", edKey, otherEd},
+	}
+
+	for _, test := range tests {
+		raw := sign(t, test.alg, "k1", test.key, Claims{Subject: "42"})
+		token, err := Parse(raw)
+		if err != nil {
+			t.Fatalf("Expected %s token parsed, got Error %s", test.alg, err)
+		}
+		if err := token.Verify(test.key.Public()); err != nil {
+			t.Errorf("Expected %s signature verified, got Error %s", test.alg, err)
+		}
+
+		// the signature must not verify with an unrelated key of the
+		// same type, or with a key of a different type
+		if err := token.Verify(test.other.Public()); err != ErrInvalidSignature {
+			t.Errorf("Expected %s ErrInvalidSignature for an unrelated key, got %v", test.alg, err)
+		}
+		if err := token.Verify(rsaKey.PublicKey.N); err != ErrInvalidSignature {
+			t.Errorf("Expected %s ErrInvalidSignature for a key type mismatch, got %v", test.alg, err)
+		}
+	}
+
+	// symmetric and unsigned tokens are never accepted
+	token, _ := Parse("eyJhbGciOiJub25lIn0.e30.")
+	if err := token.Verify(&rsaKey.PublicKey); err != ErrUnsupportedAlgorithm {
+		t.Errorf("Expected ErrUnsupportedAlgorithm for alg none, got %v", err)
+	}
+}
+
+// Test the ability to validate the registered claims, with clock skew.
+func TestValidate(t *testing.T) {
+	now := time.Unix(1600000000, 0)
+	validator := Validator{
+		Issuer:   "https://issuer.example.com",
+		Audience: "client",
+		Leeway:   time.Minute,
+		Required: []string{"exp"},
+		Now:      func() time.Time { return now },
+	}
+
+	claims := Claims{
+		Issuer:   "https://issuer.example.com",
+		Audience: Audience{"client"},
+		Expiry:   NumericDate(now.Add(-30 * time.Second).Unix()),
+	}
+	if err := validator.Validate(&claims); err != nil {
+		t.Errorf("Expected claims within leeway validated, got Error %s", err)
+	}
+
+	tests := []struct {
+		claims Claims
+		claim  string
+		err    error
+	}{
+		{Claims{Issuer: claims.Issuer, Audience: claims.Audience}, "exp", ErrMissing},
+		{Claims{Issuer: claims.Issuer, Audience: claims.Audience, Expiry: NumericDate(now.Add(-time.Hour).Unix())}, "exp", ErrExpired},
+		{Claims{Issuer: claims.Issuer, Audience: claims.Audience, Expiry: claims.Expiry, NotBefore: NumericDate(now.Add(time.Hour).Unix())}, "nbf", ErrNotValidYet},
+		{Claims{Issuer: "https://evil.com", Audience: claims.Audience, Expiry: claims.Expiry}, "iss", ErrInvalidIssuer},
+		{Claims{Issuer: claims.Issuer, Audience: Audience{"other"}, Expiry: claims.Expiry}, "aud", ErrInvalidAud},
+	}
+	for _, test := range tests {
+		err, ok := validator.Validate(&test.claims).(*ValidationError)
+		if !ok || err.Claim != test.claim || err.Err != test.err {
+			t.Errorf("Expected %s %v, got %v", test.claim, test.err, err)
+		}
+	}
+}
+
+// Test the key set is cached per the Cache-Control header, and re-fetched
+// when a token is signed with an unknown key.
+func TestRemoteKeySet(t *testing.T) {
+	ecKey, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	kid, fetches := "k1", 0
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fetches++
+		w.Header().Set("Cache-Control", "public, max-age=3600")
+		fmt.Fprintf(w, `{"keys":[{"kty":"EC","crv":"P-256","kid":%q,"x":%q,"y":%q}]}`, kid,
+			base64.RawURLEncoding.EncodeToString(ecKey.X.Bytes()),
+			base64.RawURLEncoding.EncodeToString(ecKey.Y.Bytes()))
+	}))
+	defer server.Close()
+
+	keys := NewRemoteKeySet(server.URL)
+	keys.MinRefresh = 0
+	for i := 0; i < 2; i++ {
+		if _, err := keys.Key("k1"); err != nil {
+			t.Fatalf("Expected key k1, got Error %s", err)
+		}
+	}
+	if fetches != 1 {
+		t.Errorf("Expected key set fetched once, got %d", fetches)
+	}
+
+	// the key was rotated
+	kid = "k2"
+	if _, err := keys.Key("k2"); err != nil {
+		t.Errorf("Expected rotated key k2, got Error %s", err)
+	}
+	if fetches != 2 {
+		t.Errorf("Expected key set re-fetched for unknown kid, got %d fetches", fetches)
+	}
+}
+
+// Test the ability to use the cached keys while the key set is re-fetched,
+// and to make a single fetch for concurrent lookups of an unknown key.
+func TestRemoteKeySetConcurrent(t *testing.T) {
+	ecKey, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	jwk := fmt.Sprintf(`{"keys":[{"kty":"EC","crv":"P-256","kid":"k1","x":%q,"y":%q}]}`,
+		base64.RawURLEncoding.EncodeToString(ecKey.X.Bytes()),
+		base64.RawURLEncoding.EncodeToString(ecKey.Y.Bytes()))
+
+	var fetches int32
+	release := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// every fetch after the first hangs until released
+		if atomic.AddInt32(&fetches, 1) > 1 {
+			<-release
+		}
+		fmt.Fprint(w, jwk)
+	}))
+	defer server.Close()
+
+	keys := NewRemoteKeySet(server.URL)
+	if _, err := keys.Key("k1"); err != nil {
+		t.Fatalf("Expected key k1, got Error %s", err)
+	}
+
+	// allow a single re-fetch for the unknown key
+	keys.mu.Lock()
+	keys.fetched = time.Time{}
+	keys.mu.Unlock()
+
+	var wg sync.WaitGroup
+	for i := 0; i < 5; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			keys.Key("unknown")
+		}()
+	}
+	for atomic.LoadInt32(&fetches) < 2 {
+		time.Sleep(time.Millisecond)
+	}
+
+	done := make(chan error)
+	go func() {
+		_, err := keys.Key("k1")
+		done <- err
+	}()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Errorf("Expected cached key k1, got Error %s", err)
+		}
+	case <-time.After(time.Second):
+		t.Errorf("Expected cached key k1 while the key set is fetched")
+	}
+
+	close(release)
+	wg.Wait()
+	if n := atomic.LoadInt32(&fetches); n != 2 {
+		t.Errorf("Expected a single fetch for the unknown key, got %d fetches", n-1)
+	}
+}
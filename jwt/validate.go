@@ -0,0 +1,102 @@
+package jwt
+
+import (
+	"errors"
+	"time"
+)
+
+// Reasons a claim fails validation, wrapped by a ValidationError.
+var (
+	ErrMissing        = errors.New("claim is missing")
+	ErrExpired        = errors.New("token is expired")
+	ErrNotValidYet    = errors.New("token is not valid yet")
+	ErrIssuedInFuture = errors.New("token was issued in the future")
+	ErrInvalidIssuer  = errors.New("token issuer is not accepted")
+	ErrInvalidAud     = errors.New("token audience is not accepted")
+)
+
+// ValidationError is returned when a registered claim fails validation.
+type ValidationError struct {
+	// The name of the claim (ie exp, aud).
+	Claim string
+
+	// The reason the claim failed validation (ie ErrExpired).
+	Err error
+}
+
+func (e *ValidationError) Error() string {
+	return "jwt: invalid " + e.Claim + " claim: " + e.Err.Error()
+}
+
+func (e *ValidationError) Unwrap() error {
+	return e.Err
+}
+
+// Validator validates the registered claims of a JWT.
+type Validator struct {
+	// The expected iss claim. If empty, the issuer is not validated.
+	Issuer string
+
+	// The expected aud claim. If empty, the audience is not validated.
+	Audience string
+
+	// The clock skew tolerated when validating the exp, nbf and iat
+	// claims.
+	Leeway time.Duration
+
+	// Claims that must be present (ie exp, iat).
+	Required []string
+
+	// Now returns the current time. If nil, time.Now is used.
+	Now func() time.Time
+}
+
+// Validate checks the registered claims, returning a *ValidationError if
+// a claim is missing or not accepted.
+func (v *Validator) Validate(c *Claims) error {
+	now := time.Now()
+	if v.Now != nil {
+		now = v.Now()
+	}
+
+	for _, claim := range v.Required {
+		if !c.has(claim) {
+			return &ValidationError{claim, ErrMissing}
+		}
+	}
+
+	switch {
+	case c.Expiry != 0 && now.After(c.Expiry.Time().Add(v.Leeway)):
+		return &ValidationError{"exp", ErrExpired}
+	case c.NotBefore != 0 && now.Add(v.Leeway).Before(c.NotBefore.Time()):
+		return &ValidationError{"nbf", ErrNotValidYet}
+	case c.IssuedAt != 0 && now.Add(v.Leeway).Before(c.IssuedAt.Time()):
+		return &ValidationError{"iat", ErrIssuedInFuture}
+	case len(v.Issuer) > 0 && c.Issuer != v.Issuer:
+		return &ValidationError{"iss", ErrInvalidIssuer}
+	case len(v.Audience) > 0 && !c.Audience.Contains(v.Audience):
+		return &ValidationError{"aud", ErrInvalidAud}
+	}
+	return nil
+}
+
+// has returns true if the registered claim is present.
+func (c *Claims) has(claim string) bool {
+	switch claim {
+	case "iss":
+		return len(c.Issuer) > 0
+	case "sub":
+		return len(c.Subject) > 0
+	case "aud":
+		return len(c.Audience) > 0
+	case "exp":
+		return c.Expiry != 0
+	case "nbf":
+		return c.NotBefore != 0
+	case "iat":
+		return c.IssuedAt != 0
+	case "jti":
+		return len(c.Id) > 0
+	}
+	return false
+}
@@ -0,0 +1,116 @@
+package auth
+
+import (
+	"strings"
+	"time"
+)
+
+// A Key is a cookie secret, identified by a short key id that is embedded
+// in the cookies it encodes. A Key with an empty Id decodes cookies written
+// before key rotation was enabled, which do not include a key id.
+type Key struct {
+	Id     string
+	Secret []byte
+}
+
+// A RotatingCodec is a Codec that encodes cookies with the current key, but
+// still decodes cookies encoded with previous keys.
+type RotatingCodec interface {
+	Codec
+
+	// Stale returns true if the value was encoded with a previous key, and
+	// should be re-issued using the current key.
+	Stale(value string) bool
+}
+
+// KeyringCodec is a RotatingCodec that holds several keys. New cookies are
+// encoded with the first (newest) key, and cookies encoded with any of the
+// keys are accepted. The key id is prepended to the cookie value, so that a
+// cookie is decoded using only the key that encoded it.
+type KeyringCodec struct {
+	keys   []Key
+	codecs map[string]Codec
+}
+
+// NewKeyringCodec allocates and returns a new KeyringCodec, using the
+// specified func to create the Codec for each key. Keys are ordered from
+// newest to oldest. Key ids must be unique and must not contain a period.
+func NewKeyringCodec(codec func(secret []byte) Codec, keys ...Key) *KeyringCodec {
+	if len(keys) == 0 {
+		panic("auth: keyring requires at least one key")
+	}
+
+	codecs := map[string]Codec{}
+	for _, key := range keys {
+		if strings.Contains(key.Id, ".") {
+			panic("auth: key id must not contain a period: " + key.Id)
+		}
+		if _, exists := codecs[key.Id]; exists {
+			panic("auth: duplicate key id: " + key.Id)
+		}
+		codecs[key.Id] = codec(key.Secret)
+	}
+	return &KeyringCodec{keys, codecs}
+}
+
+// NewSignedKeyring allocates and returns a KeyringCodec that signs cookies
+// using a SignedCodec for each key.
+func NewSignedKeyring(keys ...Key) *KeyringCodec {
+	return NewKeyringCodec(func(secret []byte) Codec { return NewSignedCodec(secret) }, keys...)
+}
+
+// NewEncryptedKeyring allocates and returns a KeyringCodec that encrypts
+// cookies using an EncryptedCodec for each key.
+func NewEncryptedKeyring(keys ...Key) *KeyringCodec {
+	return NewKeyringCodec(func(secret []byte) Codec { return NewEncryptedCodec(secret) }, keys...)
+}
+
+func (self *KeyringCodec) Encode(name, data string, expires time.Time) (string, error) {
+	current := self.keys[0]
+	value, err := self.codecs[current.Id].Encode(name, data, expires)
+	if err != nil || len(current.Id) == 0 {
+		return value, err
+	}
+	return current.Id + "." + value, nil
+}
+
+func (self *KeyringCodec) Decode(name, value string) (string, time.Time, error) {
+	codec, value := self.lookup(value)
+	if codec == nil {
+		return "", time.Time{}, ErrCookieTampered
+	}
+	return codec.Decode(name, value)
+}
+
+// decodeLegacy decodes a session cookie value written before the cookie
+// name was included in the signature, using the key that encoded it.
+func (self *KeyringCodec) decodeLegacy(value string) (string, time.Time, error) {
+	codec, value := self.lookup(value)
+	if legacy, ok := codec.(legacyCodec); ok {
+		return legacy.decodeLegacy(value)
+	}
+	return "", time.Time{}, ErrCookieTampered
+}
+
+func (self *KeyringCodec) Stale(value string) bool {
+	_, kid := self.keyId(value)
+	return kid != self.keys[0].Id
+}
+
+// lookup returns the Codec for the key that encoded the value, and the
+// value without the key id.
+func (self *KeyringCodec) lookup(value string) (Codec, string) {
+	value, kid := self.keyId(value)
+	return self.codecs[kid], value
+}
+
+// keyId splits the key id from the value. If the value does not start with
+// a known key id, the value is assumed to have been written without one.
+func (self *KeyringCodec) keyId(value string) (string, string) {
+	if i := strings.Index(value, "."); i > 0 {
+		if _, ok := self.codecs[value[:i]]; ok {
+			return value[i+1:], value[:i]
+		}
+	}
+	return value, ""
+}
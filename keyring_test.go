@@ -0,0 +1,54 @@
+package auth
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// Test cookies encoded with a previous key are accepted and re-issued with
+// the current key.
+func TestKeyringCodec(t *testing.T) {
+	old := NewSignedKeyring(Key{"1", []byte("old-secret")})
+	current := NewSignedKeyring(Key{"2", []byte("new-secret")}, Key{"1", []byte("old-secret")})
+
+	value, _ := old.Encode("_sess", "data", time.Now().Add(time.Hour))
+	if !strings.HasPrefix(value, "1.") {
+		t.Errorf("Expected key id 1 embedded in %v", value)
+	}
+	if data, _, err := current.Decode("_sess", value); err != nil || data != "data" {
+		t.Errorf("Expected cookie from previous key decoded, got %v %v", data, err)
+	}
+	if !current.Stale(value) {
+		t.Errorf("Expected cookie from previous key to be stale")
+	}
+
+	// a cookie from a key that was removed from the keyring is rejected
+	removed := NewSignedKeyring(Key{"2", []byte("new-secret")})
+	if _, _, err := removed.Decode("_sess", value); err != ErrCookieTampered {
+		t.Errorf("Expected ErrCookieTampered, got %v", err)
+	}
+
+	// the session is moved to the current key on the next request
+	Config.CookieCodec = old
+	rec := httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", "/auth/login", nil)
+	SetUserCookie(rec, req, &user{id: "jdoe"})
+	cookie := (&http.Response{Header: rec.Header()}).Cookies()[0]
+
+	Config.CookieCodec = current
+	Config.CookieReissue = true
+	defer func() { Config.CookieCodec, Config.CookieReissue = nil, false }()
+
+	req, _ = http.NewRequest("GET", "/private", nil)
+	req.AddCookie(cookie)
+	rec = httptest.NewRecorder()
+	SecureUser(func(w http.ResponseWriter, r *http.Request, u User) {})(rec, req)
+
+	reissued := (&http.Response{Header: rec.Header()}).Cookies()
+	if len(reissued) != 1 || !strings.HasPrefix(reissued[0].Value, "2.") {
+		t.Errorf("Expected cookie re-issued with key 2, got %v", reissued)
+	}
+}
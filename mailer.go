@@ -0,0 +1,80 @@
+package auth
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// A Mailer sends the email verification and password reset messages for
+// local accounts.
+type Mailer interface {
+
+	// Send delivers a plain text message to the email address.
+	Send(to, subject, body string) error
+}
+
+// MailerFunc is an adapter that allows an ordinary function to be used as a
+// Mailer.
+type MailerFunc func(to, subject, body string) error
+
+func (f MailerFunc) Send(to, subject, body string) error {
+	return f(to, subject, body)
+}
+
+// LogMailer is a Mailer that writes messages to a log instead of sending
+// them, for development and tests.
+type LogMailer struct {
+	Logger *log.Logger
+}
+
+// NewLogMailer allocates and returns a new LogMailer, writing to the
+// standard logger.
+func NewLogMailer() *LogMailer {
+	return &LogMailer{log.Default()}
+}
+
+func (self *LogMailer) Send(to, subject, body string) error {
+	self.Logger.Printf("mail to=%s subject=%q\n%s", to, subject, body)
+	return nil
+}
+
+// FileMailer is a Mailer that appends messages to a file instead of sending
+// them, for development and tests.
+type FileMailer struct {
+	Path string
+
+	sync.Mutex
+}
+
+// NewFileMailer allocates and returns a new FileMailer.
+func NewFileMailer(path string) *FileMailer {
+	return &FileMailer{Path: path}
+}
+
+func (self *FileMailer) Send(to, subject, body string) error {
+	self.Lock()
+	defer self.Unlock()
+
+	f, err := os.OpenFile(self.Path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0600)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	return writeMessage(f, to, subject, body)
+}
+
+// writeMessage writes the message in the Internet Message Format.
+func writeMessage(w io.Writer, to, subject, body string) error {
+	// a line break in a header would start a new header
+	if strings.ContainsAny(to+subject, "\r\n") {
+		return ErrInvalidEmail
+	}
+	_, err := fmt.Fprintf(w, "Date: %s\r\nTo: %s\r\nSubject: %s\r\n\r\n%s\r\n\r\n",
+		time.Now().Format(time.RFC1123Z), to, subject, body)
+	return err
+}
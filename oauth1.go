@@ -5,6 +5,7 @@ import (
 	"io/ioutil"
 	"net/http"
 	"net/url"
+	"time"
 
 	"github.com/bradrydzewski/go.auth/oauth1"
 )
@@ -43,25 +44,29 @@ func (self *OAuth1Mixin) AuthorizeRedirect(w http.ResponseWriter, r *http.Reques
 	}
 
 	//Get the redirect URL
-	url, err := self.Consumer.AuthorizeRedirect(token)
+	redirectTo, err := self.Consumer.AuthorizeRedirect(token)
 	if err != nil {
 		return err
 	}
 
 	//Write the Request Token to a Cookie, so that we can
 	//retrieve it after re-directing the user to the
-	//providers authorization screen.
-	cookie := http.Cookie{}
-	cookie.Name = "_token"
-	cookie.Path = "/"
-	cookie.Domain = r.URL.Host
+	//providers authorization screen. The url to return
+	//the user to after login is saved with the token.
+	config := authenticatorFor(r).config
+	values := url.Values{"token": {token.Encode()}}
+	if next := config.nextURL(r); len(next) > 0 {
+		values.Set(nextParam, next)
+	}
+	cookie := config.newCookie("_token")
 	cookie.HttpOnly = true
-	cookie.Secure = Config.CookieSecure
-	cookie.Value = token.Encode()
-	http.SetCookie(w, &cookie)
+	cookie.MaxAge = int(config.StateExp.Seconds())
+	if err := config.writeCookie(w, cookie, values.Encode(), time.Now().Add(config.StateExp)); err != nil {
+		return err
+	}
 
 	// redirect to the login url
-	http.Redirect(w, r, url, http.StatusSeeOther)
+	http.Redirect(w, r, redirectTo, http.StatusSeeOther)
 	return nil
 }
 
@@ -70,16 +75,21 @@ func (self *OAuth1Mixin) AuthorizeRedirect(w http.ResponseWriter, r *http.Reques
 func (self *OAuth1Mixin) AuthorizeToken(w http.ResponseWriter, r *http.Request) (*oauth1.AccessToken, error) {
 
 	//Get the presisted request token
-	cookie, err := r.Cookie("_token")
+	data, _, err := authenticatorFor(r).config.readCookie(r, "_token")
 	if err != nil {
 		return nil, nil
 	}
+	values, err := url.ParseQuery(data)
+	if err != nil {
+		return nil, err
+	}
 
 	//Parse the persisted request token
-	requestToken, err := oauth1.ParseRequestTokenStr(cookie.Value)
+	requestToken, err := oauth1.ParseRequestTokenStr(values.Get("token"))
 	if err != nil {
 		return nil, err
 	}
+	setNext(r, values.Get(nextParam))
 
 	//Delete the request Token ...don't need it anymore
 	DeleteUserCookieName(w,r,"_token")
@@ -1,20 +1,28 @@
 package auth
 
 import (
+	"crypto/rand"
+	"encoding/base64"
 	"encoding/json"
 	"errors"
 	"io/ioutil"
 	"net/http"
 	"net/url"
-	"math/rand"
 	"time"
-	"strconv"
 
 	"github.com/bradrydzewski/go.auth/oauth2"
 )
 
-// State generator, seeded with current time
-var stateGenerator = rand.New(rand.NewSource(time.Now().Unix()))
+// Error messages related to the OAuth2 state parameter verification
+var (
+	ErrInvalidState = errors.New("Invalid or missing OAuth2 state parameter")
+	ErrStateExpired = errors.New("OAuth2 state parameter Expired")
+)
+
+// Prefix of the cookie used to persist the state parameter between the
+// authorization redirect and the callback. The state value is appended to
+// the prefix, so that logins started in separate tabs do not collide.
+const stateCookiePrefix = "_state_"
 
 // Abstract implementation of OAuth2 for user authentication.
 type OAuth2Mixin struct {
@@ -28,27 +36,61 @@ func (self *OAuth2Mixin) RedirectRequired(r *http.Request) bool {
 	return r.URL.Query().Get("code") == ""
 }
 
-// Redirects the User to the Login Screen
+// Redirects the User to the Login Screen. A random state parameter and a
+// PKCE code_verifier are persisted in a short-lived, signed cookie so that
+// they can be verified and used when the User is redirected back.
 func (self *OAuth2Mixin) AuthorizeRedirect(w http.ResponseWriter, r *http.Request, scope string) {
-	state := strconv.FormatInt(stateGenerator.Int63(), 10)
-	url := self.Client.AuthorizeRedirect(scope, state)
+	self.authorizeRedirect(w, r, scope, url.Values{}, url.Values{})
+}
+
+// authorizeRedirect redirects the User to the Login Screen, including the
+// additional params in the redirect URL, and persisting the additional
+// values in the state cookie until the User is redirected back.
+func (self *OAuth2Mixin) authorizeRedirect(w http.ResponseWriter, r *http.Request, scope string, params, values url.Values) {
+	state := randomString(32)
+	verifier := oauth2.NewCodeVerifier()
+	values.Set("code_verifier", verifier)
+	if err := setStateCookie(w, r, state, values); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	for key, val := range self.Client.ChallengeParams(verifier) {
+		params[key] = val
+	}
+	url := self.Client.AuthorizeRedirectParams(scope, state, params)
 	http.Redirect(w, r, url, http.StatusSeeOther)
 }
 
-// Exchanges the verifier for an OAuth2 Access Token.
-func (self *OAuth2Mixin) GetAccessToken(r *http.Request) (*oauth2.Token, error) {
+// Exchanges the verifier for an OAuth2 Access Token. The state parameter
+// returned by the Provider must match the state persisted when the User
+// was redirected, otherwise ErrInvalidState or ErrStateExpired is returned.
+func (self *OAuth2Mixin) GetAccessToken(w http.ResponseWriter, r *http.Request) (*oauth2.Token, error) {
+	token, _, err := self.getAccessToken(w, r)
+	return token, err
+}
+
+// getAccessToken exchanges the verifier for an OAuth2 Access Token, and
+// returns the values persisted by authorizeRedirect.
+func (self *OAuth2Mixin) getAccessToken(w http.ResponseWriter, r *http.Request) (*oauth2.Token, url.Values, error) {
+
+	//verify the state parameter before trusting the code
+	values, err := getStateCookie(w, r, r.URL.Query().Get("state"))
+	if err != nil {
+		return nil, nil, err
+	}
 
 	code := r.URL.Query().Get("code")
 	if len(code) == 0 {
-		return nil, errors.New("No Access Code in the Request URL")
+		return nil, nil, errors.New("No Access Code in the Request URL")
 	}
 
-	accessToken, err := self.Client.GrantToken(code)
+	accessToken, err := self.Client.GrantTokenPKCE(code, values.Get("code_verifier"))
 	if err != nil {
-		return nil, err
+		return nil, nil, err
 	}
 
-	return accessToken, err
+	return accessToken, values, err
 }
 
 // Gets the Authenticated User
@@ -83,3 +125,75 @@ func (self *OAuth2Mixin) GetAuthenticatedUser(endpoint string, accessToken strin
 	//unmarshal user json
 	return json.Unmarshal(userData, &resp)
 }
+
+// setStateCookie writes a secure cookie, keyed by the state parameter, that
+// holds the state, the url to return the user to after login, and any
+// additional values required to complete the flow.
+func setStateCookie(w http.ResponseWriter, r *http.Request, state string, values url.Values) error {
+	config := authenticatorFor(r).config
+	values.Set("state", state)
+	if next := config.nextURL(r); len(next) > 0 {
+		values.Set(nextParam, next)
+	}
+	exp := time.Now().Add(config.StateExp)
+
+	cookie := config.newCookie(stateCookiePrefix + state)
+	value, err := config.codec().Encode(cookie.Name, values.Encode(), exp)
+	if err != nil {
+		return err
+	}
+
+	cookie.HttpOnly = true
+	cookie.MaxAge = int(config.StateExp.Seconds())
+	cookie.Value = value
+	config.setCookie(w, cookie)
+	return nil
+}
+
+// getStateCookie verifies the state parameter against the signed cookie
+// written by setStateCookie and returns the persisted values. The cookie
+// is deleted, so that a state can only be used once, and the saved url is
+// passed to successURL.
+func getStateCookie(w http.ResponseWriter, r *http.Request, state string) (url.Values, error) {
+	if len(state) == 0 {
+		return nil, ErrInvalidState
+	}
+
+	//get the cookie for this particular flow
+	config := authenticatorFor(r).config
+	cookie, err := r.Cookie(config.cookieName(stateCookiePrefix + state))
+	if err != nil {
+		return nil, ErrInvalidState
+	}
+
+	//the state is single use ...don't need it anymore
+	DeleteUserCookieName(w, r, cookie.Name)
+
+	//verify the cookie's signature
+	data, expires, err := config.codec().Decode(cookie.Name, cookie.Value)
+	if err != nil {
+		return nil, ErrInvalidState
+	}
+	if time.Now().After(expires) {
+		return nil, ErrStateExpired
+	}
+
+	//verify the persisted state matches the returned state
+	values, err := url.ParseQuery(data)
+	if err != nil || values.Get("state") != state {
+		return nil, ErrInvalidState
+	}
+
+	setNext(r, values.Get(nextParam))
+	return values, nil
+}
+
+// randomString returns a URL-safe string encoding size bytes read from a
+// cryptographically secure random source.
+func randomString(size int) string {
+	b := make([]byte, size)
+	if _, err := rand.Read(b); err != nil {
+		panic(err)
+	}
+	return base64.RawURLEncoding.EncodeToString(b)
+}
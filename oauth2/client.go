@@ -4,6 +4,9 @@ package oauth2
 // see http://tools.ietf.org/html/draft-ietf-oauth-v2-31
 
 import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
 	"encoding/json"
 	"io/ioutil"
 	"net/http"
@@ -34,13 +37,48 @@ type Client struct {
 	// Used by the client to obtain authorization from the resource
 	// owner via user-agent redirection.
 	AuthorizationURL string
+
+	// The method used to derive the PKCE code_challenge from the
+	// code_verifier, either S256 or plain. If empty, S256 is used.
+	//
+	// See http://tools.ietf.org/html/rfc7636#section-4.2
+	CodeChallengeMethod string
 }
 
 // AuthorizeRedirect constructs the Authorization Endpoint, where the user
 // can authorize the client to access protected resources.
 func (c *Client) AuthorizeRedirect(scope, state string) string {
-	// add required parameters
+	return c.AuthorizeRedirectParams(scope, state, make(url.Values))
+}
+
+// AuthorizeRedirectPKCE constructs the Authorization Endpoint, including
+// the code_challenge derived from the specified code_verifier. The same
+// code_verifier must be passed to GrantTokenPKCE when exchanging the
+// authorization code.
+//
+// See http://tools.ietf.org/html/rfc7636#section-4.3
+func (c *Client) AuthorizeRedirectPKCE(scope, state, verifier string) string {
+	return c.AuthorizeRedirectParams(scope, state, c.ChallengeParams(verifier))
+}
+
+// ChallengeParams returns the code_challenge and code_challenge_method
+// parameters derived from the specified code_verifier.
+func (c *Client) ChallengeParams(verifier string) url.Values {
+	method := c.CodeChallengeMethod
+	if len(method) == 0 {
+		method = CodeChallengeS256
+	}
+
 	params := make(url.Values)
+	params.Set("code_challenge", CodeChallenge(verifier, method))
+	params.Set("code_challenge_method", method)
+	return params
+}
+
+// AuthorizeRedirectParams constructs the Authorization Endpoint, including
+// additional, provider-specific parameters (ie nonce, prompt).
+func (c *Client) AuthorizeRedirectParams(scope, state string, params url.Values) string {
+	// add required parameters
 	params.Add("response_type", ResponseTypeCode)
 	//params.Set("redirect_uri", c.RedirectURL)
 	params.Set("client_id", c.ClientId)
@@ -95,6 +133,20 @@ func (c *Client) GrantToken(code string) (*Token, error) {
 	return c.grantToken(params)
 }
 
+// GrantTokenPKCE will attempt to grant an Access Token using the
+// specified authorization code and the code_verifier that was used to
+// create the code_challenge.
+//
+// See http://tools.ietf.org/html/rfc7636#section-4.5
+func (c *Client) GrantTokenPKCE(code, verifier string) (*Token, error) {
+	params := make(url.Values)
+	params.Set("grant_type", GrantTypeAuthorizationCode)
+	params.Set("code", code)
+	params.Set("code_verifier", verifier)
+	params.Set("scope", "")
+	return c.grantToken(params)
+}
+
 // GrantTokenCredentials will attempt to grant an Access Token
 // for the Client to access protected resources the the Client owns.
 //
@@ -150,6 +202,9 @@ type Token struct {
 
 	// The scope of the access token.
 	Scope string
+
+	// The OpenID Connect ID Token, if the openid scope was requested.
+	IdToken string `json:"id_token"`
 }
 
 func (t Token) Token() string {
@@ -186,10 +241,13 @@ func (c *Client) grantToken(params url.Values) (*Token, error) {
 		params = make(url.Values)
 	}
 
-	// Add the client id, client secret and code to the query params
+	// Add the client id, client secret and code to the query params.
+	// Public clients (ie command-line utilities) do not have a secret.
 	params.Set("client_id", c.ClientId)
-	params.Set("client_secret", c.ClientSecret)
 	params.Set("redirect_uri", c.RedirectURL)
+	if len(c.ClientSecret) > 0 {
+		params.Set("client_secret", c.ClientSecret)
+	}
 
 	// Create the access token request url
 	endpoint, _ := url.Parse(c.AccessTokenURL)
@@ -247,3 +305,27 @@ func (c *Client) grantToken(params url.Values) (*Token, error) {
 
 	return &token, nil
 }
+
+// NewCodeVerifier returns a high-entropy cryptographic random string to be
+// used as the PKCE code_verifier.
+//
+// See http://tools.ietf.org/html/rfc7636#section-4.1
+func NewCodeVerifier() string {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		panic(err)
+	}
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+// CodeChallenge derives the PKCE code_challenge from the code_verifier
+// using the specified method (S256 or plain).
+//
+// See http://tools.ietf.org/html/rfc7636#section-4.2
+func CodeChallenge(verifier, method string) string {
+	if method == CodeChallengePlain {
+		return verifier
+	}
+	sum := sha256.Sum256([]byte(verifier))
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
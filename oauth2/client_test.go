@@ -0,0 +1,37 @@
+package oauth2
+
+import (
+	"net/url"
+	"testing"
+)
+
+// Test the ability to derive a code_challenge, using the example from
+// http://tools.ietf.org/html/rfc7636#appendix-B
+func TestCodeChallenge(t *testing.T) {
+	verifier := "dBjftJeZ4CVP-mB92K27uhbUJU1p1r_wW1gFWFOEjXk"
+	expected := "E9Melhoa2OwvFrEMTJguCHaoeK1t8URWbuGJSstw-cM"
+
+	if challenge := CodeChallenge(verifier, CodeChallengeS256); challenge != expected {
+		t.Errorf("Expected S256 code_challenge %v, got %v", expected, challenge)
+	}
+	if challenge := CodeChallenge(verifier, CodeChallengePlain); challenge != verifier {
+		t.Errorf("Expected plain code_challenge %v, got %v", verifier, challenge)
+	}
+}
+
+// Test the code_challenge is included in the Authorization Endpoint.
+func TestAuthorizeRedirectPKCE(t *testing.T) {
+	client := Client{
+		ClientId:         "cli",
+		AuthorizationURL: "https://example.com/authorize",
+	}
+
+	redirect, _ := url.Parse(client.AuthorizeRedirectPKCE("", "xyz", "verifier"))
+	params := redirect.Query()
+	if method := params.Get("code_challenge_method"); method != CodeChallengeS256 {
+		t.Errorf("Expected code_challenge_method %v, got %v", CodeChallengeS256, method)
+	}
+	if challenge := params.Get("code_challenge"); challenge != CodeChallenge("verifier", CodeChallengeS256) {
+		t.Errorf("Expected code_challenge for verifier, got %v", challenge)
+	}
+}
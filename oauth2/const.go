@@ -32,6 +32,16 @@ const (
 	ResponseTypeToken = "token"
 )
 
+// Enumerates the methods (code_challenge_method) used to derive
+// the PKCE code_challenge from the code_verifier.
+const (
+	// code_challenge = BASE64URL(SHA256(code_verifier))
+	CodeChallengeS256 = "S256"
+
+	// code_challenge = code_verifier
+	CodeChallengePlain = "plain"
+)
+
 const (
 	TokenBearer = "bearer"
 	TokenMac    = "mac"
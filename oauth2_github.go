@@ -1,9 +1,17 @@
 package auth
 
 import (
+	"encoding/json"
+	"errors"
+	"fmt"
 	"net/http"
+	"strings"
 )
 
+// ErrGithubMembership is returned when a GithubProvider is restricted to
+// organizations or teams, and the User is not a member of any of them.
+var ErrGithubMembership = errors.New("GitHub user is not a member of an allowed organization or team")
+
 type GitHubUser struct {
 	UserEmail    interface{} `json:"email"`
 	UserName     interface{} `json:"name"`
@@ -11,6 +19,12 @@ type GitHubUser struct {
 	UserCompany  interface{} `json:"company"`
 	UserLink     interface{} `json:"html_url"`
 	UserLogin    string      `json:"login"`
+
+	// Orgs and Teams hold the User's organization and team memberships,
+	// if the read:org scope was granted. Teams are in the format
+	// "org/team-slug".
+	Orgs  []string `json:"-"`
+	Teams []string `json:"-"`
 }
 
 func (u *GitHubUser) Id() string       { return u.UserLogin }
@@ -47,34 +61,89 @@ func (u *GitHubUser) Org() string {
 	return u.UserCompany.(string)
 }
 
+// Data returns the User's organization and team memberships, comma
+// separated, so that they are stored in the session. They can be used as
+// roles with SessionRoles{"github_orgs"} or SessionRoles{"github_teams"}.
+func (u *GitHubUser) Data() map[string]string {
+	data := map[string]string{}
+	if len(u.Orgs) > 0 {
+		data["github_orgs"] = strings.Join(u.Orgs, ",")
+	}
+	if len(u.Teams) > 0 {
+		data["github_teams"] = strings.Join(u.Teams, ",")
+	}
+	return data
+}
+
 
 // GithubProvider is an implementation of Github's Oauth2 protocol.
 // See http://developer.github.com/v3/oauth/
 type GithubProvider struct {
 	OAuth2Mixin
 	Scope string
+
+	// Orgs and Teams restrict logins to members of any of the
+	// organizations, or any of the teams, in the format "org/team-slug".
+	// The read:org scope is requested when either is set.
+	Orgs  []string
+	Teams []string
+
+	// APIURL is the base url of the GitHub API.
+	APIURL string
+}
+
+// A GithubOption configures a GithubProvider.
+type GithubOption func(*GithubProvider)
+
+// GithubOrgs restricts logins to members of any of the organizations.
+func GithubOrgs(orgs ...string) GithubOption {
+	return func(p *GithubProvider) { p.Orgs = append(p.Orgs, orgs...) }
+}
+
+// GithubTeams restricts logins to members of any of the teams, in the
+// format "org/team-slug".
+func GithubTeams(teams ...string) GithubOption {
+	return func(p *GithubProvider) { p.Teams = append(p.Teams, teams...) }
 }
 
 // NewGithubProvider allocates and returns a new GithubProvider.
-func NewGithubProvider(clientId, clientSecret, scope string) *GithubProvider {
+func NewGithubProvider(clientId, clientSecret, scope string, opts ...GithubOption) *GithubProvider {
 	github := GithubProvider{}
 	github.AuthorizationURL = "https://github.com/login/oauth/authorize"
 	github.AccessTokenURL   = "https://github.com/login/oauth/access_token"
 	github.ClientId         = clientId
 	github.ClientSecret     = clientSecret
 	github.Scope            = scope
+	github.APIURL           = "https://api.github.com"
 
 	// default the Scope if not provided
 	if len(github.Scope) == 0 {
 		github.Scope = "user:email"
 	}
+	for _, opt := range opts {
+		opt(&github)
+	}
 	return &github
 }
 
 // Redirect will do an http.Redirect, sending the user to the Github login
 // screen.
 func (self *GithubProvider) Redirect(w http.ResponseWriter, r *http.Request) {
-	self.OAuth2Mixin.AuthorizeRedirect(w, r, self.Scope)
+	self.OAuth2Mixin.AuthorizeRedirect(w, r, self.scope())
+}
+
+// scope returns the Scope, including read:org if the provider is restricted
+// to organizations or teams.
+func (self *GithubProvider) scope() string {
+	if len(self.Orgs) == 0 && len(self.Teams) == 0 {
+		return self.Scope
+	}
+	for _, s := range strings.FieldsFunc(self.Scope, func(r rune) bool { return r == ' ' || r == ',' }) {
+		if s == "read:org" || s == "admin:org" {
+			return self.Scope
+		}
+	}
+	return self.Scope + " read:org"
 }
 
 // GetAuthenticatedUser will retrieve the Authentication User from the
@@ -82,12 +151,135 @@ func (self *GithubProvider) Redirect(w http.ResponseWriter, r *http.Request) {
 func (self *GithubProvider) GetAuthenticatedUser(w http.ResponseWriter, r *http.Request) (User, Token, error) {
 
 	// Get the OAuth2 Access Token
-	token, err := self.GetAccessToken(r)
+	token, err := self.GetAccessToken(w, r)
 	if err != nil {
 		return nil, nil, err
 	}
 
 	user := GitHubUser{}
-	err = self.OAuth2Mixin.GetAuthenticatedUser("https://api.github.com/user", token.AccessToken, &user)
-	return &user, token, err
+	err = self.OAuth2Mixin.GetAuthenticatedUser(self.APIURL+"/user", token.AccessToken, &user)
+	if err != nil || (len(self.Orgs) == 0 && len(self.Teams) == 0) {
+		return &user, token, err
+	}
+
+	// Get the User's memberships, and verify the User is a member of an
+	// allowed organization or team
+	if err := self.getMemberships(token.AccessToken, &user); err != nil {
+		return nil, nil, err
+	}
+	if !containsFold(self.Orgs, user.Orgs) && !containsFold(self.Teams, user.Teams) {
+		return nil, nil, ErrGithubMembership
+	}
+	return &user, token, nil
+}
+
+// getMemberships retrieves the User's organizations and teams. Only the
+// first 100 of each are retrieved.
+func (self *GithubProvider) getMemberships(accessToken string, user *GitHubUser) error {
+	orgs, err := self.apiList("/user/orgs?per_page=100", accessToken)
+	if err != nil {
+		return err
+	}
+	for _, raw := range orgs {
+		org := struct {
+			Login string `json:"login"`
+		}{}
+		if err := json.Unmarshal(raw, &org); err != nil {
+			return err
+		}
+		user.Orgs = append(user.Orgs, org.Login)
+	}
+
+	teams, err := self.apiList("/user/teams?per_page=100", accessToken)
+	if err != nil {
+		return err
+	}
+	for _, raw := range teams {
+		team := struct {
+			Slug         string `json:"slug"`
+			Organization struct {
+				Login string `json:"login"`
+			} `json:"organization"`
+		}{}
+		if err := json.Unmarshal(raw, &team); err != nil {
+			return err
+		}
+		user.Teams = append(user.Teams, team.Organization.Login+"/"+team.Slug)
+	}
+	return nil
+}
+
+// apiList requests every page of the GitHub API list resource, following
+// the Link header, and returns the items.
+//
+// See https://docs.github.com/en/rest/using-the-rest-api/using-pagination-in-the-rest-api
+func (self *GithubProvider) apiList(path, accessToken string) ([]json.RawMessage, error) {
+	items := []json.RawMessage{}
+	for next := self.APIURL + path; len(next) > 0; {
+		page := []json.RawMessage{}
+		res, err := self.apiRequest(next, accessToken, &page)
+		if err != nil {
+			return nil, err
+		}
+		items = append(items, page...)
+
+		// the access token is only sent to the GitHub API
+		next = nextLink(res.Header.Get("Link"))
+		if !strings.HasPrefix(next, self.APIURL+"/") {
+			next = ""
+		}
+	}
+	return items, nil
+}
+
+// apiRequest requests the GitHub API url, authorized with the access token,
+// and unmarshals the JSON response.
+func (self *GithubProvider) apiRequest(target, accessToken string, resp interface{}) (*http.Response, error) {
+	req, err := http.NewRequest("GET", target, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Authorization", "Bearer "+accessToken)
+	req.Header.Set("Accept", "application/vnd.github+json")
+
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("GitHub API %s returned %s", req.URL.Path, res.Status)
+	}
+	return res, json.NewDecoder(res.Body).Decode(resp)
+}
+
+// nextLink returns the url of the next page from a Link header, or an
+// empty string if there is no next page.
+func nextLink(header string) string {
+	for _, link := range strings.Split(header, ",") {
+		target, params, _ := strings.Cut(link, ";")
+		target = strings.TrimSpace(target)
+		if !strings.HasPrefix(target, "<") || !strings.HasSuffix(target, ">") {
+			continue
+		}
+		for _, param := range strings.Split(params, ";") {
+			if strings.TrimSpace(param) == `rel="next"` {
+				return target[1 : len(target)-1]
+			}
+		}
+	}
+	return ""
+}
+
+// containsFold returns true if any of the values are in the list, ignoring
+// case, since GitHub logins and slugs are case-insensitive.
+func containsFold(list, values []string) bool {
+	for _, a := range list {
+		for _, b := range values {
+			if strings.EqualFold(a, b) {
+				return true
+			}
+		}
+	}
+	return false
 }
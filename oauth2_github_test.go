@@ -0,0 +1,52 @@
+package auth
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// Test the ability to request the read:org scope, and retrieve the
+// organization and team memberships of a GitHub user.
+func TestGithubMemberships(t *testing.T) {
+	api := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer gho_abc" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		switch r.URL.Path {
+		case "/user/orgs":
+			// the second page is linked from the first
+			if r.URL.Query().Get("page") == "2" {
+				w.Write([]byte(`[{"login":"drone"}]`))
+				return
+			}
+			w.Header().Set("Link", fmt.Sprintf(`<http://%s/user/orgs?per_page=100&page=2>; rel="next", <http://%s/user/orgs?per_page=100&page=2>; rel="last"`, r.Host, r.Host))
+			w.Write([]byte(`[{"login":"gitea"}]`))
+		case "/user/teams":
+			w.Write([]byte(`[{"slug":"core","organization":{"login":"drone"}}]`))
+		}
+	}))
+	defer api.Close()
+
+	github := NewGithubProvider("client", "secret", "", GithubTeams("Drone/core"))
+	github.APIURL = api.URL
+	if scope := github.scope(); scope != "user:email read:org" {
+		t.Errorf("Expected scope user:email read:org, got %s", scope)
+	}
+
+	user := GitHubUser{UserLogin: "octocat"}
+	if err := github.getMemberships("gho_abc", &user); err != nil {
+		t.Fatalf("Expected memberships, got Error %s", err.Error())
+	}
+	if data := user.Data(); data["github_orgs"] != "gitea,drone" || data["github_teams"] != "drone/core" {
+		t.Errorf("Expected org drone and team drone/core, got %v", data)
+	}
+	if !containsFold(github.Teams, user.Teams) {
+		t.Errorf("Expected team membership matched ignoring case")
+	}
+	if containsFold([]string{"other"}, user.Orgs) {
+		t.Errorf("Expected membership of another org rejected")
+	}
+}
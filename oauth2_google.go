@@ -1,9 +1,16 @@
 package auth
 
 import (
+	"errors"
 	"net/http"
+	"net/url"
+	"strings"
 )
 
+// ErrGoogleHostedDomain is returned when a GoogleProvider is restricted to
+// hosted domains, and the User's account does not belong to one of them.
+var ErrGoogleHostedDomain = errors.New("Google account does not belong to an allowed domain")
+
 // GoogleUser represents a Google user object returned by the OAuth2 service.
 type GoogleUser struct {
 	UserId      string `json:"id"`
@@ -11,6 +18,11 @@ type GoogleUser struct {
 	UserPicture string `json:"picture"`
 	UserName    string `json:"name"`
 	UserLink    string `json:"link"`
+
+	// HostedDomain is the Google Workspace domain of the account, and
+	// is empty for consumer accounts.
+	HostedDomain  string `json:"hd"`
+	VerifiedEmail bool   `json:"verified_email"`
 }
 
 func (u *GoogleUser) Id() string       { return u.UserId }
@@ -19,23 +31,61 @@ func (u *GoogleUser) Name() string     { return u.UserName }
 func (u *GoogleUser) Email() string    { return u.UserEmail }
 func (u *GoogleUser) Picture() string  { return u.UserPicture }
 func (u *GoogleUser) Link() string     { return u.UserLink }
-func (u *GoogleUser) Org() string      { return "" }
+func (u *GoogleUser) Org() string      { return u.HostedDomain }
 
 // GoogleProvider is an implementation of Google's Oauth2 
 // for web application flow.
 // See https://developers.google.com/accounts/docs/OAuth2WebServer
 type GoogleProvider struct {
 	OAuth2Mixin
+
+	// HostedDomains restricts logins to accounts of the Google Workspace
+	// domains, with a verified email address.
+	HostedDomains []string
+
+	// LoginHint pre-fills the email address on the Google login screen.
+	// A login_hint query parameter of the login request takes precedence.
+	LoginHint string
+
+	// Prompt is a space separated list of prompts for the User, ie
+	// "select_account" or "consent".
+	Prompt string
+
+	// UserInfoURL is the url of the userinfo endpoint.
+	UserInfoURL string
+}
+
+// A GoogleOption configures a GoogleProvider.
+type GoogleOption func(*GoogleProvider)
+
+// GoogleHostedDomains restricts logins to accounts of the Google Workspace
+// domains.
+func GoogleHostedDomains(domains ...string) GoogleOption {
+	return func(p *GoogleProvider) { p.HostedDomains = append(p.HostedDomains, domains...) }
+}
+
+// GoogleLoginHint pre-fills the email address on the Google login screen.
+func GoogleLoginHint(email string) GoogleOption {
+	return func(p *GoogleProvider) { p.LoginHint = email }
+}
+
+// GooglePrompt sets the prompts for the User, ie "select_account".
+func GooglePrompt(prompt string) GoogleOption {
+	return func(p *GoogleProvider) { p.Prompt = prompt }
 }
 
 // NewGoogleProvider allocates and returns a new GoogleProvider.
-func NewGoogleProvider(client, secret, redirect string) *GoogleProvider {
+func NewGoogleProvider(client, secret, redirect string, opts ...GoogleOption) *GoogleProvider {
 	goog := GoogleProvider{}
 	goog.AuthorizationURL = "https://accounts.google.com/o/oauth2/auth"
 	goog.AccessTokenURL   = "https://accounts.google.com/o/oauth2/token"
 	goog.RedirectURL      = redirect
 	goog.ClientId         = client
 	goog.ClientSecret     = secret
+	goog.UserInfoURL      = "https://www.googleapis.com/oauth2/v2/userinfo"
+	for _, opt := range opts {
+		opt(&goog)
+	}
 	return &goog
 }
 
@@ -43,19 +93,68 @@ func NewGoogleProvider(client, secret, redirect string) *GoogleProvider {
 // screen.
 func (self *GoogleProvider) Redirect(w http.ResponseWriter, r *http.Request) {
 	const scope = "https://www.googleapis.com/auth/userinfo.profile+https://www.googleapis.com/auth/userinfo.email"
-	self.OAuth2Mixin.AuthorizeRedirect(w, r, scope)
+	self.OAuth2Mixin.authorizeRedirect(w, r, scope, self.redirectParams(r), url.Values{})
+}
+
+// redirectParams returns the hosted domain, login hint and prompt params of
+// the redirect to the Google login screen.
+func (self *GoogleProvider) redirectParams(r *http.Request) url.Values {
+	params := url.Values{}
+
+	// the hd param only hints which accounts to show, the hd claim of
+	// the User is still verified. "*" shows only Workspace accounts.
+	switch len(self.HostedDomains) {
+	case 0:
+	case 1:
+		params.Set("hd", self.HostedDomains[0])
+	default:
+		params.Set("hd", "*")
+	}
+
+	if hint := r.URL.Query().Get("login_hint"); len(hint) > 0 {
+		params.Set("login_hint", hint)
+	} else if len(self.LoginHint) > 0 {
+		params.Set("login_hint", self.LoginHint)
+	}
+	if len(self.Prompt) > 0 {
+		params.Set("prompt", self.Prompt)
+	}
+	return params
 }
 
 // GetAuthenticatedUser will retrieve the Authentication User from the
 // http.Request object.
 func (self *GoogleProvider) GetAuthenticatedUser(w http.ResponseWriter, r *http.Request) (User, Token, error) {
 	// Get the OAuth2 Access Token
-	token, err := self.GetAccessToken(r)
+	token, err := self.GetAccessToken(w, r)
 	if err != nil {
 		return nil, nil, err
 	}
 
 	user := GoogleUser{}
-	err = self.OAuth2Mixin.GetAuthenticatedUser("https://www.googleapis.com/oauth2/v2/userinfo", token.AccessToken, &user)
-	return &user, token, err
+	err = self.OAuth2Mixin.GetAuthenticatedUser(self.UserInfoURL, token.AccessToken, &user)
+	if err != nil {
+		return nil, nil, err
+	}
+	if !self.allowed(&user) {
+		return nil, nil, ErrGoogleHostedDomain
+	}
+	return &user, token, nil
+}
+
+// allowed returns true if the provider is not restricted to hosted domains,
+// or the User's verified account belongs to one of them.
+func (self *GoogleProvider) allowed(user *GoogleUser) bool {
+	if len(self.HostedDomains) == 0 {
+		return true
+	}
+	if !user.VerifiedEmail || len(user.HostedDomain) == 0 {
+		return false
+	}
+	for _, domain := range self.HostedDomains {
+		if strings.EqualFold(domain, user.HostedDomain) {
+			return true
+		}
+	}
+	return false
 }
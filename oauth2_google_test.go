@@ -0,0 +1,55 @@
+package auth
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+)
+
+// Test the ability to send the hosted domain, login hint and prompt params
+// to the Google login screen.
+func TestGoogleRedirect(t *testing.T) {
+	t.Parallel()
+	config := NewConfig()
+	config.CookieSecret = []byte("7H9xiimk2QdTdYI7rDddfJeV")
+	google := NewGoogleProvider("client", "secret", "http://localhost/auth/login",
+		GoogleHostedDomains("example.com"),
+		GooglePrompt("select_account"))
+
+	w := httptest.NewRecorder()
+	r, _ := http.NewRequest("GET", "/auth/login?login_hint=alice@example.com", nil)
+	google.Redirect(w, NewAuthenticator(config).withAuthenticator(r))
+
+	location, _ := url.Parse(w.Header().Get("Location"))
+	params := location.Query()
+	if params.Get("hd") != "example.com" || params.Get("login_hint") != "alice@example.com" || params.Get("prompt") != "select_account" {
+		t.Errorf("Expected hd, login_hint and prompt params, got %s", location.RawQuery)
+	}
+}
+
+// Test the ability to reject Google accounts that do not belong to an
+// allowed hosted domain.
+func TestGoogleHostedDomain(t *testing.T) {
+	google := NewGoogleProvider("client", "secret", "", GoogleHostedDomains("example.com", "example.org"))
+
+	tests := []struct {
+		user    GoogleUser
+		allowed bool
+	}{
+		{GoogleUser{HostedDomain: "example.com", VerifiedEmail: true}, true},
+		{GoogleUser{HostedDomain: "Example.ORG", VerifiedEmail: true}, true},
+		{GoogleUser{HostedDomain: "example.com", VerifiedEmail: false}, false},
+		{GoogleUser{HostedDomain: "evil.com", VerifiedEmail: true}, false},
+		{GoogleUser{VerifiedEmail: true}, false},
+	}
+	for _, test := range tests {
+		if got := google.allowed(&test.user); got != test.allowed {
+			t.Errorf("Expected hd %q verified %v allowed %v, got %v", test.user.HostedDomain, test.user.VerifiedEmail, test.allowed, got)
+		}
+	}
+
+	if params := google.redirectParams(&http.Request{URL: &url.URL{}}); params.Get("hd") != "*" {
+		t.Errorf("Expected hd * for several domains, got %q", params.Get("hd"))
+	}
+}
@@ -0,0 +1,55 @@
+package auth
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+)
+
+// Test the ability to verify the state parameter persisted by setStateCookie.
+func TestStateCookie(t *testing.T) {
+	t.Parallel()
+	config := NewConfig()
+	config.CookieSecret = []byte("7H9xiimk2QdTdYI7rDddfJeV")
+	a := NewAuthenticator(config)
+
+	rec := httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", "/auth/login", nil)
+	req = a.withAuthenticator(req)
+	setStateCookie(rec, req, "abc123", url.Values{})
+
+	resp := http.Response{Header: rec.Header()}
+	cookies := resp.Cookies()
+	if len(cookies) != 1 || cookies[0].Name != stateCookiePrefix+"abc123" {
+		t.Fatalf("Expected a single state cookie, got %v", cookies)
+	}
+
+	callback, _ := http.NewRequest("GET", "/auth/login?code=1&state=abc123", nil)
+	callback = a.withAuthenticator(callback)
+	callback.AddCookie(cookies[0])
+	if _, err := getStateCookie(httptest.NewRecorder(), callback, "abc123"); err != nil {
+		t.Errorf("Expected state verified, got Error %s", err.Error())
+	}
+
+	// a state returned by the provider must match the cookie
+	forged, _ := http.NewRequest("GET", "/auth/login?code=1&state=xyz", nil)
+	forged = a.withAuthenticator(forged)
+	forged.AddCookie(cookies[0])
+	if _, err := getStateCookie(httptest.NewRecorder(), forged, "xyz"); err != ErrInvalidState {
+		t.Errorf("Expected ErrInvalidState, got %v", err)
+	}
+
+	// a tampered cookie value must not verify
+	tampered := *cookies[0]
+	tampered.Value = "x" + tampered.Value[1:]
+	if tampered.Value == cookies[0].Value {
+		tampered.Value = "y" + tampered.Value[1:]
+	}
+	forged, _ = http.NewRequest("GET", "/auth/login?code=1&state=abc123", nil)
+	forged = a.withAuthenticator(forged)
+	forged.AddCookie(&tampered)
+	if _, err := getStateCookie(httptest.NewRecorder(), forged, "abc123"); err != ErrInvalidState {
+		t.Errorf("Expected ErrInvalidState, got %v", err)
+	}
+}
@@ -0,0 +1,289 @@
+package auth
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/bradrydzewski/go.auth/jwt"
+)
+
+// Error messages related to OpenID Connect ID Token validation
+var (
+	ErrIdTokenMissing  = errors.New("OpenID Connect ID Token missing from the token response")
+	ErrIdTokenInvalid  = errors.New("OpenID Connect ID Token is invalid")
+	ErrIdTokenExpired  = errors.New("OpenID Connect ID Token Expired")
+	ErrIssuerMismatch  = errors.New("OpenID Connect issuer does not match the configured issuer")
+	ErrNonceMismatch   = errors.New("OpenID Connect nonce does not match the authentication request")
+	ErrSubjectMismatch = errors.New("OpenID Connect userinfo subject does not match the ID Token")
+)
+
+// Maximum clock skew tolerated when validating the exp and iat claims.
+const oidcClockSkew = time.Minute * 5
+
+// OIDCUser represents the claims of an ID Token, merged with the claims
+// returned by the OpenID Connect userinfo endpoint.
+type OIDCUser struct {
+	UserId      string `json:"sub"`
+	UserName    string `json:"name"`
+	UserEmail   string `json:"email"`
+	UserPicture string `json:"picture"`
+	UserLink    string `json:"profile"`
+	UserIssuer  string `json:"iss"`
+
+	// Claims holds every claim returned by the Provider, including any
+	// non-standard claims.
+	Claims map[string]interface{} `json:"-"`
+}
+
+func (u *OIDCUser) Id() string       { return u.UserId }
+func (u *OIDCUser) Provider() string { return u.UserIssuer }
+func (u *OIDCUser) Name() string     { return u.UserName }
+func (u *OIDCUser) Picture() string  { return u.UserPicture }
+func (u *OIDCUser) Link() string     { return u.UserLink }
+func (u *OIDCUser) Org() string      { return "" }
+
+// Email returns the User's email address, only if the Provider asserts the
+// address was verified with the email_verified claim.
+func (u *OIDCUser) Email() string {
+	switch verified := u.Claims["email_verified"].(type) {
+	case bool:
+		if verified {
+			return u.UserEmail
+		}
+	case string:
+		// some Providers encode the claim as a string
+		if verified == "true" {
+			return u.UserEmail
+		}
+	}
+	return ""
+}
+
+// oidcConfig is the subset of the OpenID Provider Metadata used by the
+// OIDCProvider.
+//
+// See http://openid.net/specs/openid-connect-discovery-1_0.html#ProviderMetadata
+type oidcConfig struct {
+	Issuer                string `json:"issuer"`
+	AuthorizationEndpoint string `json:"authorization_endpoint"`
+	TokenEndpoint         string `json:"token_endpoint"`
+	UserinfoEndpoint      string `json:"userinfo_endpoint"`
+	JwksURI               string `json:"jwks_uri"`
+}
+
+// OIDCProvider is an implementation of the OpenID Connect authorization
+// code flow, configured from the Provider's discovery document. It can be
+// used with any compliant Provider (ie Keycloak, Okta, Auth0, Dex).
+// See http://openid.net/specs/openid-connect-core-1_0.html
+type OIDCProvider struct {
+	OAuth2Mixin
+	Issuer string
+	Scope  string
+
+	sync.Mutex
+	config *oidcConfig
+	keys   *jwt.RemoteKeySet
+}
+
+// NewOIDCProvider allocates and returns a new OIDCProvider. The Provider's
+// endpoints are discovered from the issuer when they are first needed.
+func NewOIDCProvider(issuer, clientId, clientSecret, redirect string) *OIDCProvider {
+	oidc := OIDCProvider{}
+	oidc.Issuer       = issuer
+	oidc.Scope        = "openid profile email"
+	oidc.ClientId     = clientId
+	oidc.ClientSecret = clientSecret
+	oidc.RedirectURL  = redirect
+	return &oidc
+}
+
+// Redirect will do an http.Redirect, sending the user to the Provider's
+// login screen, including a nonce that is bound to the ID Token.
+func (self *OIDCProvider) Redirect(w http.ResponseWriter, r *http.Request) {
+	if _, err := self.discover(); err != nil {
+		http.Error(w, err.Error(), http.StatusBadGateway)
+		return
+	}
+
+	nonce := randomString(32)
+	params := url.Values{"nonce": {nonce}}
+	self.OAuth2Mixin.authorizeRedirect(w, r, self.Scope, params, url.Values{"nonce": {nonce}})
+}
+
+// GetAuthenticatedUser will retrieve the Authentication User from the
+// validated ID Token, and from the userinfo endpoint if the Provider has one.
+func (self *OIDCProvider) GetAuthenticatedUser(w http.ResponseWriter, r *http.Request) (User, Token, error) {
+	config, err := self.discover()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	// Get the OAuth2 Access Token
+	token, values, err := self.getAccessToken(w, r)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	user, err := self.validateIdToken(token.IdToken, values.Get("nonce"))
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if len(config.UserinfoEndpoint) > 0 {
+		if err := self.userinfo(config.UserinfoEndpoint, token.AccessToken, user); err != nil {
+			return nil, nil, err
+		}
+	}
+
+	return user, token, nil
+}
+
+// validateIdToken verifies the ID Token's signature against the Provider's
+// JSON Web Key Set, and validates its claims.
+//
+// See http://openid.net/specs/openid-connect-core-1_0.html#IDTokenValidation
+func (self *OIDCProvider) validateIdToken(raw, nonce string) (*OIDCUser, error) {
+	if len(raw) == 0 {
+		return nil, ErrIdTokenMissing
+	}
+
+	token, err := jwt.Verify(raw, self.keys)
+	if err != nil {
+		return nil, err
+	}
+
+	claims := struct {
+		jwt.Claims
+		Nonce           string `json:"nonce"`
+		AuthorizedParty string `json:"azp"`
+	}{}
+	if err := token.Claims(&claims); err != nil {
+		return nil, ErrIdTokenInvalid
+	}
+
+	// validate the iss, aud, exp and iat claims
+	validator := jwt.Validator{
+		Issuer:   self.Issuer,
+		Audience: self.ClientId,
+		Leeway:   oidcClockSkew,
+		Required: []string{"iss", "sub", "aud", "exp", "iat"},
+	}
+	if err := validator.Validate(&claims.Claims); err != nil {
+		if verr, ok := err.(*jwt.ValidationError); ok {
+			switch verr.Err {
+			case jwt.ErrExpired:
+				return nil, ErrIdTokenExpired
+			case jwt.ErrInvalidIssuer:
+				return nil, ErrIssuerMismatch
+			}
+		}
+		return nil, err
+	}
+
+	switch {
+	case len(claims.Audience) > 1 && len(claims.AuthorizedParty) == 0:
+		return nil, ErrIdTokenInvalid
+	case len(claims.AuthorizedParty) > 0 && claims.AuthorizedParty != self.ClientId:
+		return nil, ErrIdTokenInvalid
+	case len(nonce) == 0 || claims.Nonce != nonce:
+		return nil, ErrNonceMismatch
+	}
+
+	user := OIDCUser{}
+	if err := token.Claims(&user); err != nil {
+		return nil, ErrIdTokenInvalid
+	}
+	if err := token.Claims(&user.Claims); err != nil {
+		return nil, ErrIdTokenInvalid
+	}
+	return &user, nil
+}
+
+// userinfo merges the claims returned by the userinfo endpoint into the
+// User. The userinfo subject must match the ID Token subject.
+func (self *OIDCProvider) userinfo(endpoint, accessToken string, user *OIDCUser) error {
+	req, err := http.NewRequest("GET", endpoint, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "Bearer "+accessToken)
+	req.Header.Set("Accept", "application/json")
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("OpenID Connect userinfo request failed, status %d", resp.StatusCode)
+	}
+
+	claims := map[string]interface{}{}
+	if err := json.NewDecoder(resp.Body).Decode(&claims); err != nil {
+		return err
+	}
+	if claims["sub"] != user.UserId {
+		return ErrSubjectMismatch
+	}
+
+	// the issuer is not returned by the userinfo endpoint, and must not
+	// be overwritten
+	delete(claims, "iss")
+	for key, val := range claims {
+		user.Claims[key] = val
+	}
+
+	raw, _ := json.Marshal(claims)
+	return json.Unmarshal(raw, user)
+}
+
+// discover retrieves the Provider's discovery document, and configures the
+// OAuth2 endpoints and JSON Web Key Set. The document is cached once it has
+// been retrieved successfully.
+//
+// See http://openid.net/specs/openid-connect-discovery-1_0.html
+func (self *OIDCProvider) discover() (*oidcConfig, error) {
+	self.Lock()
+	config := self.config
+	self.Unlock()
+	if config != nil {
+		return config, nil
+	}
+
+	// the document is retrieved without holding the lock, so a slow
+	// issuer doesn't block other requests that already have the config
+	resp, err := http.DefaultClient.Get(strings.TrimSuffix(self.Issuer, "/") + "/.well-known/openid-configuration")
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("OpenID Connect discovery failed, status %d", resp.StatusCode)
+	}
+
+	config = &oidcConfig{}
+	if err := json.NewDecoder(resp.Body).Decode(config); err != nil {
+		return nil, err
+	}
+	if config.Issuer != self.Issuer {
+		return nil, ErrIssuerMismatch
+	}
+
+	self.Lock()
+	defer self.Unlock()
+	if self.config != nil {
+		// another request completed discovery first
+		return self.config, nil
+	}
+	self.AuthorizationURL = config.AuthorizationEndpoint
+	self.AccessTokenURL   = config.TokenEndpoint
+	self.keys   = jwt.NewRemoteKeySet(config.JwksURI)
+	self.config = config
+	return self.config, nil
+}
@@ -0,0 +1,84 @@
+package auth
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// Test the ability to validate an ID Token signed with a key from the
+// Provider's JSON Web Key Set.
+func TestValidateIdToken(t *testing.T) {
+	key, _ := rsa.GenerateKey(rand.Reader, 2048)
+
+	var issuer string
+	op := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/.well-known/openid-configuration":
+			fmt.Fprintf(w, `{"issuer":%q,"authorization_endpoint":"%s/auth","token_endpoint":"%s/token","jwks_uri":"%s/keys"}`, issuer, issuer, issuer, issuer)
+		case "/keys":
+			fmt.Fprintf(w, `{"keys":[{"kty":"RSA","kid":"k1","use":"sig","n":%q,"e":%q}]}`,
+				base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+				base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()))
+		}
+	}))
+	defer op.Close()
+	issuer = op.URL
+
+	provider := NewOIDCProvider(issuer, "client", "secret", "")
+	if _, err := provider.discover(); err != nil {
+		t.Fatalf("Expected discovery document, got Error %s", err.Error())
+	}
+
+	sign := func(claims map[string]interface{}) string {
+		header, _ := json.Marshal(map[string]string{"alg": "RS256", "kid": "k1"})
+		payload, _ := json.Marshal(claims)
+		signed := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(payload)
+		sum := sha256.Sum256([]byte(signed))
+		sig, _ := rsa.SignPKCS1v15(rand.Reader, key, crypto.SHA256, sum[:])
+		return signed + "." + base64.RawURLEncoding.EncodeToString(sig)
+	}
+
+	now := time.Now().Unix()
+	claims := map[string]interface{}{
+		"iss": issuer, "sub": "42", "aud": "client", "exp": now + 60, "iat": now,
+		"nonce": "n1", "email": "jdoe@example.com", "email_verified": true,
+	}
+
+	user, err := provider.validateIdToken(sign(claims), "n1")
+	if err != nil {
+		t.Fatalf("Expected ID Token validated, got Error %s", err.Error())
+	}
+	if user.Id() != "42" || user.Email() != "jdoe@example.com" || user.Provider() != issuer {
+		t.Errorf("Expected User 42 jdoe@example.com, got %v", user)
+	}
+
+	claims["email_verified"] = false
+	if user, _ := provider.validateIdToken(sign(claims), "n1"); user == nil || user.Email() != "" {
+		t.Errorf("Expected unverified email omitted, got %v", user)
+	}
+
+	if _, err := provider.validateIdToken(sign(claims), "n2"); err != ErrNonceMismatch {
+		t.Errorf("Expected ErrNonceMismatch, got %v", err)
+	}
+
+	claims["aud"] = []string{"client", "other"}
+	if _, err := provider.validateIdToken(sign(claims), "n1"); err != ErrIdTokenInvalid {
+		t.Errorf("Expected ErrIdTokenInvalid without azp, got %v", err)
+	}
+
+	claims["aud"] = "client"
+	claims["exp"] = now - 3600
+	if _, err := provider.validateIdToken(sign(claims), "n1"); err != ErrIdTokenExpired {
+		t.Errorf("Expected ErrIdTokenExpired, got %v", err)
+	}
+}
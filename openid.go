@@ -1,10 +1,12 @@
 package auth
 
 import (
+	"bufio"
 	"errors"
 	"fmt"
 	"net/http"
 	"net/url"
+	"strings"
 )
 
 const (
@@ -15,6 +17,17 @@ var (
 	ErrAuthDeclined = errors.New("Login was unsuccessful or cancelled by User")
 )
 
+// Error messages related to the OpenId assertion verification
+var (
+	ErrInvalidAssertion = errors.New("OpenId assertion could not be verified")
+	ErrEndpointMismatch = errors.New("OpenId op_endpoint does not match the configured endpoint")
+	ErrReturnToMismatch = errors.New("OpenId return_to does not match the current URL")
+	ErrNonceReplayed    = errors.New("OpenId response_nonce is expired or was already used")
+)
+
+// Namespace of the OpenId Attribute Exchange extension
+const openIdAxNamespace = "http://openid.net/srv/ax/1.0"
+
 var openIdParams = map[string]string{
 	"openid.ns":                "http://specs.openid.net/auth/2.0",
 	"openid.ns.ax":             "http://openid.net/srv/ax/1.0",
@@ -27,18 +40,33 @@ var openIdParams = map[string]string{
 	"openid.ax.type.lastname":  "http://axschema.org/namePerson/last",
 	"openid.ax.type.firstname": "http://axschema.org/namePerson/first",
 	"openid.ax.type.email":     "http://axschema.org/contact/email",
-	"openid.claimed_id":        "http://specs.openid.net/auth/2.0/identifier_select",
-	"openid.identity":          "http://specs.openid.net/auth/2.0/identifier_select",
+	"openid.claimed_id":        identifierSelect,
+	"openid.identity":          identifierSelect,
 }
 
 // Base implementation of OpenID for user authentication.
 type OpenIdProvider struct {
 	endpoint string
+
+	// Nonces records the openid.response_nonce of every verified assertion,
+	// in order to reject assertions that are replayed. If Nonces is nil the
+	// assertion is rejected.
+	Nonces NonceStore
+
+	// Associations caches the shared secrets established with the OpenId
+	// Provider, so that assertions can be verified without a round trip.
+	// If Associations is nil the provider runs in stateless mode, and every
+	// assertion is verified directly with the OpenId Provider.
+	Associations AssociationStore
 }
 
 // NewOpenIdProvider allocates and returns a new OpenIdProvider.
+//
+// If the endpoint is empty the OpenId Provider is discovered from the
+// identifier entered by the User (ie example.com), which must be submitted
+// to the login URL in the openid_identifier parameter.
 func NewOpenIdProvider(endpoint string) *OpenIdProvider {
-	return &OpenIdProvider{ endpoint }
+	return &OpenIdProvider{ endpoint: endpoint, Nonces: NewMemoryNonceStore() }
 }
 
 func (self *OpenIdProvider) RedirectRequired(r *http.Request) bool {
@@ -56,41 +84,286 @@ func (self *OpenIdProvider) Redirect(w http.ResponseWriter, r *http.Request) {
 		params.Add(key, val)
 	}
 
+	// discover the OpenId Provider from the User's identifier, when an
+	// endpoint is not configured
+	endpoint := self.endpoint
+	if len(endpoint) == 0 {
+		service, err := discoverIdentifier(r.FormValue("openid_identifier"))
+		if err != nil {
+			// the error may describe the server's network, and is not
+			// returned to the client
+			http.Error(w, ErrDiscoveryFailed.Error(), http.StatusBadRequest)
+			return
+		}
+		endpoint = service.Endpoint
+
+		// request authentication of the Claimed Identifier, unless
+		// the identifier is an OP Identifier
+		if len(service.ClaimedId) > 0 {
+			params.Set("openid.claimed_id", service.ClaimedId)
+			params.Set("openid.identity", service.Identity())
+		}
+	}
+
 	// append the real and return_to parameters
-	// they will be defaulted to the current Host / Path
-	// TODO use url.New().String() instead string joins below
-	params.Add("openid.realm", "http://"+r.Host)
-	params.Add("openid.return_to", "http://"+r.Host+r.URL.Path)
+	// they will be defaulted to the current Host / Path, and the return_to
+	// includes the url to send the user to after login
+	realm := currentURL(r)
+	realm.Path = ""
+	params.Add("openid.realm", realm.String())
+	returnTo := currentURL(r)
+	if next := authenticatorFor(r).config.nextURL(r); len(next) > 0 {
+		returnTo.RawQuery = url.Values{nextParam: {next}}.Encode()
+	}
+	params.Add("openid.return_to", returnTo.String())
+
+	// append the association handle, when running in stateful mode
+	if handle := self.associationHandle(endpoint); len(handle) > 0 {
+		params.Add("openid.assoc_handle", handle)
+	}
 
 	// create the redirect url
-	redirectTo, _ := url.Parse(self.endpoint)
+	redirectTo, _ := url.Parse(endpoint)
 	redirectTo.RawQuery = params.Encode()
 
 	// redirect to login
 	http.Redirect(w, r, redirectTo.String(), http.StatusSeeOther)
 }
 
-// GetAuthenticatedUser will retrieve the User information from the URL
-// query parameters, per the OpenID specification. If the authentication failed,
-// the function will return an error.
+// GetAuthenticatedUser will verify the positive assertion sent by the OpenId
+// Provider and retrieve the User information from the signed URL query
+// parameters, per the OpenID specification. If the authentication failed, or
+// the assertion cannot be verified, the function will return an error.
+//
+// See http://openid.net/specs/openid-authentication-2_0.html#verification
 func (self *OpenIdProvider) GetAuthenticatedUser(w http.ResponseWriter, r *http.Request) (User, Token, error) {
 
 	// Parse the url parameters
 	params := r.URL.Query()
 
 	// Check to see if the user successfully authenticated
-	if params.Get("openid.mode") == "cancel" {
+	switch params.Get("openid.mode") {
+	case "id_res":
+	case "cancel":
 		return nil, nil, ErrAuthDeclined
+	default:
+		return nil, nil, ErrInvalidAssertion
 	}
 
-	// Get the user details from the URL parameters
-	lastName := params.Get("openid.ext1.value.lastname")
-	firstName := params.Get("openid.ext1.value.firstname")
-	fullName := fmt.Sprintf("%s %s", firstName, lastName)
-	email := params.Get("openid.ext1.value.email")
+	// Verify the assertion was issued by the configured endpoint, or
+	// when discovering endpoints, that the endpoint is authorized to make
+	// assertions about the Claimed Identifier
+	endpoint := params.Get("openid.op_endpoint")
+	if len(self.endpoint) > 0 && endpoint != self.endpoint {
+		return nil, nil, ErrEndpointMismatch
+	}
+	if len(self.endpoint) == 0 {
+		if !isSigned(params, "claimed_id", "identity") {
+			return nil, nil, ErrInvalidAssertion
+		}
+		if err := verifyClaimedId(params); err != nil {
+			return nil, nil, err
+		}
+	}
+
+	// Verify the assertion was intended for this URL
+	if err := verifyReturnTo(r, params.Get("openid.return_to")); err != nil {
+		return nil, nil, err
+	}
+
+	// Verify the signature
+	if err := self.verifySignature(endpoint, params); err != nil {
+		return nil, nil, err
+	}
 
-	// Return the User data
+	// Verify the assertion is not being replayed
+	if self.Nonces == nil || !self.Nonces.Accept(endpoint, params.Get("openid.response_nonce")) {
+		return nil, nil, ErrNonceReplayed
+	}
+
+	// The url to send the user to after login is included in the signed
+	// return_to url
+	setNext(r, params.Get(nextParam))
+
+	// Get the user details from the signed Attribute Exchange parameters
+	ax := signedAxValues(params)
+	fullName := fmt.Sprintf("%s %s", ax["firstname"], ax["lastname"])
+	email := ax["email"]
+
+	// Return the User data. When the endpoint is discovered the Provider
+	// is not trusted to assert an email address, so the User is identified
+	// by the verified Claimed Identifier instead, and the email is omitted.
 	// TODO for now we are re-using the Google User
-	user := user{id: email, email: email, name: fullName, provider: self.endpoint }
+	user := user{id: email, email: email, name: fullName, provider: endpoint }
+	if len(self.endpoint) == 0 {
+		user.id = params.Get("openid.claimed_id")
+		user.email = ""
+	} else if len(email) == 0 {
+		// the configured Provider identifies the User by the signed
+		// email address, so an assertion without one is rejected
+		return nil, nil, ErrInvalidAssertion
+	}
 	return &user, nil, nil
 }
+
+// verifySignature verifies the signature of the assertion, using a cached
+// association if one exists for the assoc_handle, or else by asking the
+// OpenId Provider directly.
+//
+// See http://openid.net/specs/openid-authentication-2_0.html#verifying_signatures
+func (self *OpenIdProvider) verifySignature(endpoint string, params url.Values) error {
+
+	// the signature must cover the fields required by the spec
+	if !isSigned(params, "op_endpoint", "return_to", "response_nonce", "assoc_handle") {
+		return ErrInvalidAssertion
+	}
+
+	// an openid.invalidate_handle parameter is not trusted, since it can be
+	// added to the query by anyone. If the provider no longer recognizes
+	// the handle, the assertion is verified directly, and the handle is
+	// deleted once the provider confirms it is invalid
+	if self.Associations != nil {
+		assoc, err := self.Associations.Get(endpoint, params.Get("openid.assoc_handle"))
+		if err == nil {
+			return assoc.Verify(params)
+		}
+	}
+
+	return self.verifyDirect(endpoint, params)
+}
+
+// verifyDirect asks the OpenId Provider to verify the signature of the
+// assertion, using the check_authentication mode.
+func (self *OpenIdProvider) verifyDirect(endpoint string, params url.Values) error {
+
+	// copy the assertion, changing only the mode
+	values := make(url.Values)
+	for key, val := range params {
+		if strings.HasPrefix(key, "openid.") {
+			values[key] = val
+		}
+	}
+	values.Set("openid.mode", "check_authentication")
+
+	resp, err := self.httpClient().PostForm(endpoint, values)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	kv, err := parseKeyValue(resp)
+	if err != nil {
+		return err
+	}
+
+	// the provider confirms the handle we sent is no longer valid
+	if handle := kv["invalidate_handle"]; len(handle) > 0 && self.Associations != nil {
+		self.Associations.Delete(endpoint, handle)
+	}
+
+	if kv["is_valid"] != "true" {
+		return ErrInvalidAssertion
+	}
+	return nil
+}
+
+// httpClient returns the http.Client used to contact the OpenId Provider.
+// A discovered Provider's endpoint is chosen by the User, and is contacted
+// using the discoveryClient, which only connects to public addresses.
+func (self *OpenIdProvider) httpClient() *http.Client {
+	if len(self.endpoint) == 0 {
+		return discoveryClient
+	}
+	return http.DefaultClient
+}
+
+// verifyReturnTo checks the openid.return_to URL matches the URL of the
+// current request, and that any query parameters included in the return_to
+// URL are also present in the current request.
+//
+// See http://openid.net/specs/openid-authentication-2_0.html#verify_return_to
+func verifyReturnTo(r *http.Request, returnTo string) error {
+	expected, err := url.Parse(returnTo)
+	if err != nil {
+		return ErrReturnToMismatch
+	}
+
+	current := currentURL(r)
+	if expected.Scheme != current.Scheme || expected.Host != current.Host || expected.Path != current.Path {
+		return ErrReturnToMismatch
+	}
+
+	query := r.URL.Query()
+	for key, val := range expected.Query() {
+		if query.Get(key) != val[0] {
+			return ErrReturnToMismatch
+		}
+	}
+	return nil
+}
+
+// currentURL returns the absolute URL of the current request, without the
+// query string.
+func currentURL(r *http.Request) *url.URL {
+	scheme := "http"
+	if r.TLS != nil {
+		scheme = "https"
+	}
+	return &url.URL{Scheme: scheme, Host: r.Host, Path: r.URL.Path}
+}
+
+// isSigned returns true if every one of the specified fields is included
+// in the openid.signed list.
+func isSigned(params url.Values, fields ...string) bool {
+	signed := map[string]bool{}
+	for _, field := range strings.Split(params.Get("openid.signed"), ",") {
+		signed[field] = true
+	}
+	for _, field := range fields {
+		if !signed[field] {
+			return false
+		}
+	}
+	return true
+}
+
+// signedAxValues returns the Attribute Exchange values that are covered by
+// the signature, keyed by their type alias (ie email, firstname). The AX
+// namespace alias is chosen by the Provider (ie ext1), so it is looked up
+// from the openid.ns.* parameters.
+func signedAxValues(params url.Values) map[string]string {
+	values := map[string]string{}
+
+	alias := ""
+	for key, val := range params {
+		if strings.HasPrefix(key, "openid.ns.") && val[0] == openIdAxNamespace {
+			alias = strings.TrimPrefix(key, "openid.ns.")
+		}
+	}
+	if len(alias) == 0 {
+		return values
+	}
+
+	prefix := alias + ".value."
+	for _, field := range strings.Split(params.Get("openid.signed"), ",") {
+		if strings.HasPrefix(field, prefix) {
+			values[strings.TrimPrefix(field, prefix)] = params.Get("openid." + field)
+		}
+	}
+	return values
+}
+
+// parseKeyValue parses an OpenId Key-Value Form encoded response body.
+//
+// See http://openid.net/specs/openid-authentication-2_0.html#kvform
+func parseKeyValue(resp *http.Response) (map[string]string, error) {
+	values := map[string]string{}
+	scanner := bufio.NewScanner(resp.Body)
+	for scanner.Scan() {
+		parts := strings.SplitN(scanner.Text(), ":", 2)
+		if len(parts) == 2 {
+			values[parts[0]] = parts[1]
+		}
+	}
+	return values, scanner.Err()
+}
@@ -0,0 +1,283 @@
+package auth
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"hash"
+	"math/big"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Error messages related to OpenId associations
+var (
+	ErrAssociationNotFound = errors.New("OpenId association not found")
+	ErrAssociationFailed   = errors.New("OpenId association could not be established")
+)
+
+// Association types and their matching Diffie-Hellman session types.
+const (
+	AssocHmacSha1   = "HMAC-SHA1"
+	AssocHmacSha256 = "HMAC-SHA256"
+	SessionDhSha1   = "DH-SHA1"
+	SessionDhSha256 = "DH-SHA256"
+)
+
+// Associations that expire within this window are not used when redirecting
+// the User, since they may expire before the User returns.
+const openIdAssocMargin = time.Minute * 5
+
+// Default Diffie-Hellman modulus and generator.
+//
+// See http://openid.net/specs/openid-authentication-2_0.html#pvalue
+var (
+	dhModulus, _ = new(big.Int).SetString("DCF93A0B883972EC0E19989AC5A2CE310E1D37717E8D9571BB7623731866E61EF75A2E27898B057F9891C2E27A639C3F29B60814581CD3B2CA3986D2683705577D45C2E7E52DC81C7A171876E5CEA74B1448BFDFAF18828EFD2519F14E45E3826634AF1949E5B535CC829A483B8A76223E5D490A257F05BDFF16F2FB22C583AB", 16)
+	dhGen        = big.NewInt(2)
+)
+
+// Association represents a shared secret established between the Relying
+// Party and an OpenId Provider, used to verify assertions without making
+// a direct verification request.
+type Association struct {
+	Handle  string    // the openid.assoc_handle value
+	Type    string    // HMAC-SHA1 or HMAC-SHA256
+	Secret  []byte    // the MAC key
+	Expires time.Time // the time at which the association expires
+}
+
+// Expired returns true if the Association has expired, or will expire
+// within the specified duration.
+func (a *Association) Expired(within time.Duration) bool {
+	return time.Now().Add(within).After(a.Expires)
+}
+
+// Verify checks the openid.sig parameter, which is computed over the fields
+// listed in openid.signed using the Association's MAC key.
+//
+// See http://openid.net/specs/openid-authentication-2_0.html#generating_signatures
+func (a *Association) Verify(params url.Values) error {
+	sig, err := base64.StdEncoding.DecodeString(params.Get("openid.sig"))
+	if err != nil {
+		return ErrInvalidAssertion
+	}
+
+	mac := hmac.New(assocHash(a.Type), a.Secret)
+	for _, field := range strings.Split(params.Get("openid.signed"), ",") {
+		mac.Write([]byte(field + ":" + params.Get("openid."+field) + "\n"))
+	}
+
+	if !hmac.Equal(sig, mac.Sum(nil)) {
+		return ErrInvalidAssertion
+	}
+	return nil
+}
+
+// An AssociationStore is used by the OpenIdProvider to cache associations
+// until they expire. Applications running multiple instances should provide
+// a shared implementation, since the User may return to any instance.
+type AssociationStore interface {
+
+	// Get returns the Association with the specified handle, or
+	// ErrAssociationNotFound.
+	Get(endpoint, handle string) (*Association, error)
+
+	// Latest returns the most recently stored Association for the
+	// endpoint, or ErrAssociationNotFound.
+	Latest(endpoint string) (*Association, error)
+
+	// Put stores the Association for the endpoint.
+	Put(endpoint string, assoc *Association) error
+
+	// Delete removes the Association with the specified handle.
+	Delete(endpoint, handle string) error
+}
+
+// MemoryAssociationStore is an in-memory implementation of AssociationStore,
+// suitable for applications running a single instance.
+type MemoryAssociationStore struct {
+	sync.Mutex
+	assocs map[string][]*Association
+}
+
+// NewMemoryAssociationStore allocates and returns a new
+// MemoryAssociationStore.
+func NewMemoryAssociationStore() *MemoryAssociationStore {
+	return &MemoryAssociationStore{assocs: map[string][]*Association{}}
+}
+
+func (self *MemoryAssociationStore) Get(endpoint, handle string) (*Association, error) {
+	self.Lock()
+	defer self.Unlock()
+	for _, assoc := range self.assocs[endpoint] {
+		if assoc.Handle == handle && !assoc.Expired(0) {
+			return assoc, nil
+		}
+	}
+	return nil, ErrAssociationNotFound
+}
+
+func (self *MemoryAssociationStore) Latest(endpoint string) (*Association, error) {
+	self.Lock()
+	defer self.Unlock()
+	assocs := self.assocs[endpoint]
+	if len(assocs) == 0 || assocs[len(assocs)-1].Expired(0) {
+		return nil, ErrAssociationNotFound
+	}
+	return assocs[len(assocs)-1], nil
+}
+
+func (self *MemoryAssociationStore) Put(endpoint string, assoc *Association) error {
+	self.Lock()
+	defer self.Unlock()
+
+	//remove expired associations
+	assocs := []*Association{}
+	for _, a := range self.assocs[endpoint] {
+		if !a.Expired(0) {
+			assocs = append(assocs, a)
+		}
+	}
+	self.assocs[endpoint] = append(assocs, assoc)
+	return nil
+}
+
+func (self *MemoryAssociationStore) Delete(endpoint, handle string) error {
+	self.Lock()
+	defer self.Unlock()
+	assocs := []*Association{}
+	for _, a := range self.assocs[endpoint] {
+		if a.Handle != handle {
+			assocs = append(assocs, a)
+		}
+	}
+	self.assocs[endpoint] = assocs
+	return nil
+}
+
+// associationHandle returns the handle of a valid association with the
+// OpenId Provider, establishing a new association if required. An empty
+// string is returned if the provider is running in stateless mode, or if
+// an association cannot be established, in which case assertions will be
+// verified directly.
+func (self *OpenIdProvider) associationHandle(endpoint string) string {
+	if self.Associations == nil {
+		return ""
+	}
+
+	assoc, err := self.Associations.Latest(endpoint)
+	if err == nil && !assoc.Expired(openIdAssocMargin) {
+		return assoc.Handle
+	}
+
+	//prefer SHA256, falling back to SHA1 if the provider doesn't support it
+	assoc, err = associate(self.httpClient(), endpoint, AssocHmacSha256, SessionDhSha256)
+	if err != nil {
+		assoc, err = associate(self.httpClient(), endpoint, AssocHmacSha1, SessionDhSha1)
+	}
+	if err != nil {
+		return ""
+	}
+
+	if err := self.Associations.Put(endpoint, assoc); err != nil {
+		return ""
+	}
+	return assoc.Handle
+}
+
+// associate establishes a shared secret with the OpenId Provider using a
+// Diffie-Hellman key exchange.
+//
+// See http://openid.net/specs/openid-authentication-2_0.html#associations
+func associate(client *http.Client, endpoint, assocType, sessionType string) (*Association, error) {
+
+	//generate the private and public keys
+	private, err := rand.Int(rand.Reader, new(big.Int).Sub(dhModulus, big.NewInt(2)))
+	if err != nil {
+		return nil, err
+	}
+	private.Add(private, big.NewInt(1))
+	public := new(big.Int).Exp(dhGen, private, dhModulus)
+
+	values := make(url.Values)
+	values.Set("openid.ns", openIdParams["openid.ns"])
+	values.Set("openid.mode", "associate")
+	values.Set("openid.assoc_type", assocType)
+	values.Set("openid.session_type", sessionType)
+	values.Set("openid.dh_consumer_public", base64.StdEncoding.EncodeToString(btwoc(public)))
+
+	resp, err := client.PostForm(endpoint, values)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	kv, err := parseKeyValue(resp)
+	if err != nil {
+		return nil, err
+	}
+
+	//the provider may reject the association type (ie unsupported-type)
+	if len(kv["error_code"]) > 0 || kv["assoc_type"] != assocType || kv["session_type"] != sessionType {
+		return nil, ErrAssociationFailed
+	}
+
+	serverPublic, err := base64.StdEncoding.DecodeString(kv["dh_server_public"])
+	if err != nil {
+		return nil, ErrAssociationFailed
+	}
+	encMacKey, err := base64.StdEncoding.DecodeString(kv["enc_mac_key"])
+	if err != nil {
+		return nil, ErrAssociationFailed
+	}
+	expiresIn, err := strconv.ParseInt(kv["expires_in"], 10, 64)
+	if err != nil || len(kv["assoc_handle"]) == 0 {
+		return nil, ErrAssociationFailed
+	}
+
+	//the MAC key is encrypted with the hash of the shared secret
+	shared := new(big.Int).Exp(new(big.Int).SetBytes(serverPublic), private, dhModulus)
+	h := assocHash(assocType)()
+	h.Write(btwoc(shared))
+	digest := h.Sum(nil)
+	if len(digest) != len(encMacKey) {
+		return nil, ErrAssociationFailed
+	}
+
+	secret := make([]byte, len(digest))
+	for i := range digest {
+		secret[i] = digest[i] ^ encMacKey[i]
+	}
+
+	return &Association{
+		Handle:  kv["assoc_handle"],
+		Type:    assocType,
+		Secret:  secret,
+		Expires: time.Now().Add(time.Duration(expiresIn) * time.Second),
+	}, nil
+}
+
+// assocHash returns the hash function used by the association type.
+func assocHash(assocType string) func() hash.Hash {
+	if assocType == AssocHmacSha1 {
+		return sha1.New
+	}
+	return sha256.New
+}
+
+// btwoc returns the big-endian two's complement representation of a
+// non-negative integer, as required by the OpenId specification.
+func btwoc(x *big.Int) []byte {
+	b := x.Bytes()
+	if len(b) == 0 || b[0]&0x80 != 0 {
+		b = append([]byte{0}, b...)
+	}
+	return b
+}
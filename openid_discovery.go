@@ -0,0 +1,415 @@
+package auth
+
+import (
+	"encoding/xml"
+	"errors"
+	"html"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/netip"
+	"net/url"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// Error messages related to OpenId discovery
+var (
+	ErrIdentifierRequired = errors.New("OpenId identifier is required")
+	ErrInvalidIdentifier  = errors.New("OpenId identifier is invalid or not supported")
+	ErrDiscoveryFailed    = errors.New("OpenId Provider could not be discovered for the identifier")
+	ErrClaimedIdMismatch  = errors.New("OpenId claimed_id does not belong to the OpenId Provider")
+)
+
+// OpenId service types advertised in XRDS documents.
+const (
+	openIdServerType = "http://specs.openid.net/auth/2.0/server"
+	openIdSignonType = "http://specs.openid.net/auth/2.0/signon"
+	identifierSelect = "http://specs.openid.net/auth/2.0/identifier_select"
+)
+
+// Maximum number of bytes read from a discovered document.
+const discoveryMaxBytes = 1 << 20
+
+// ErrPrivateAddress is returned when a discovered url resolves to a
+// loopback, private or link-local address.
+var ErrPrivateAddress = errors.New("OpenId url resolves to a private address")
+
+// discoveryClient is the http.Client used to contact urls chosen by the User
+// or by a discovered OpenId Provider. It only connects to public addresses
+// over http or https, so that it can't be used to reach services on the
+// server's own network.
+var discoveryClient = &http.Client{
+	Timeout: time.Second * 10,
+	Transport: &http.Transport{
+		DialContext: (&net.Dialer{
+			Timeout: time.Second * 5,
+			Control: publicAddressOnly,
+		}).DialContext,
+		TLSHandshakeTimeout: time.Second * 5,
+	},
+	CheckRedirect: func(req *http.Request, via []*http.Request) error {
+		if len(via) >= 10 {
+			return ErrDiscoveryFailed
+		}
+		return checkDiscoveryURL(req.URL)
+	},
+}
+
+// checkDiscoveryURL returns an error if the url is not an http or https url.
+func checkDiscoveryURL(u *url.URL) error {
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return ErrInvalidIdentifier
+	}
+	return nil
+}
+
+// publicAddressOnly is a net.Dialer Control func that refuses connections
+// to addresses that are not globally reachable, such as loopback, private,
+// link-local and shared (carrier-grade NAT) addresses. It is called with the
+// resolved address, so a host name can't be used to bypass it.
+func publicAddressOnly(network, address string, c syscall.RawConn) error {
+	addrPort, err := netip.ParseAddrPort(address)
+	if err != nil || !publicAddress(addrPort.Addr()) {
+		return ErrPrivateAddress
+	}
+	return nil
+}
+
+// nonPublicPrefixes are the address blocks that are not globally reachable.
+//
+// See https://www.iana.org/assignments/iana-ipv4-special-registry
+// and https://www.iana.org/assignments/iana-ipv6-special-registry
+var nonPublicPrefixes = []netip.Prefix{
+	netip.MustParsePrefix("0.0.0.0/8"),
+	netip.MustParsePrefix("10.0.0.0/8"),
+	netip.MustParsePrefix("100.64.0.0/10"),
+	netip.MustParsePrefix("127.0.0.0/8"),
+	netip.MustParsePrefix("169.254.0.0/16"),
+	netip.MustParsePrefix("172.16.0.0/12"),
+	netip.MustParsePrefix("192.0.0.0/24"),
+	netip.MustParsePrefix("192.0.2.0/24"),
+	netip.MustParsePrefix("192.88.99.0/24"),
+	netip.MustParsePrefix("192.168.0.0/16"),
+	netip.MustParsePrefix("198.18.0.0/15"),
+	netip.MustParsePrefix("198.51.100.0/24"),
+	netip.MustParsePrefix("203.0.113.0/24"),
+	netip.MustParsePrefix("224.0.0.0/4"),
+	netip.MustParsePrefix("240.0.0.0/4"),
+	netip.MustParsePrefix("::/128"),
+	netip.MustParsePrefix("::1/128"),
+	netip.MustParsePrefix("64:ff9b:1::/48"),
+	netip.MustParsePrefix("100::/64"),
+	netip.MustParsePrefix("2001::/23"),
+	netip.MustParsePrefix("2001:db8::/32"),
+	netip.MustParsePrefix("fc00::/7"),
+	netip.MustParsePrefix("fe80::/10"),
+	netip.MustParsePrefix("ff00::/8"),
+}
+
+// Address blocks of IPv6 addresses that embed an IPv4 address.
+var (
+	nat64Prefix = netip.MustParsePrefix("64:ff9b::/96")
+	sixToFour   = netip.MustParsePrefix("2002::/16")
+)
+
+// publicAddress returns true if the address is globally reachable. An IPv4
+// address embedded in an IPv6 address must also be globally reachable.
+func publicAddress(addr netip.Addr) bool {
+	addr = addr.Unmap()
+	if !addr.IsValid() || addr.Zone() != "" {
+		return false
+	}
+	for _, prefix := range nonPublicPrefixes {
+		if prefix.Contains(addr) {
+			return false
+		}
+	}
+
+	b := addr.As16()
+	switch {
+	case nat64Prefix.Contains(addr):
+		return publicAddress(netip.AddrFrom4([4]byte{b[12], b[13], b[14], b[15]}))
+	case sixToFour.Contains(addr):
+		return publicAddress(netip.AddrFrom4([4]byte{b[2], b[3], b[4], b[5]}))
+	}
+	return true
+}
+
+// openIdService is an OpenId Provider endpoint discovered for an
+// identifier. If ClaimedId is empty, the identifier is an OP Identifier
+// and the Provider will select the identity of the User.
+type openIdService struct {
+	Endpoint  string
+	ClaimedId string
+	LocalId   string
+}
+
+// Identity returns the OP-Local Identifier, which defaults to the
+// Claimed Identifier.
+func (s *openIdService) Identity() string {
+	if len(s.LocalId) > 0 {
+		return s.LocalId
+	}
+	return s.ClaimedId
+}
+
+// XRDS document returned by Yadis discovery.
+type xrdsDocument struct {
+	XRD []struct {
+		Service []xrdsService `xml:"Service"`
+	} `xml:"XRD"`
+}
+
+type xrdsService struct {
+	Priority string   `xml:"priority,attr"`
+	Type     []string `xml:"Type"`
+	URI      []string `xml:"URI"`
+	LocalID  string   `xml:"LocalID"`
+}
+
+// priority returns the service priority, where a missing priority is
+// treated as the lowest possible priority.
+func (s *xrdsService) priority() int {
+	p, err := strconv.Atoi(s.Priority)
+	if err != nil || p < 0 {
+		return int(^uint(0) >> 1)
+	}
+	return p
+}
+
+// Expressions used to find the discovery elements of an HTML document.
+var (
+	htmlLinkTag  = regexp.MustCompile(`(?is)<link\s[^>]*>`)
+	htmlMetaTag  = regexp.MustCompile(`(?is)<meta\s[^>]*>`)
+	htmlAttrExpr = regexp.MustCompile(`(?is)([a-z\-]+)\s*=\s*(?:"([^"]*)"|'([^']*)'|([^\s>]+))`)
+)
+
+// normalizeIdentifier normalizes a User-supplied identifier, such as
+// example.com, to a URL.
+//
+// See http://openid.net/specs/openid-authentication-2_0.html#normalization
+func normalizeIdentifier(identifier string) (string, error) {
+	identifier = strings.TrimSpace(identifier)
+	identifier = strings.TrimPrefix(identifier, "xri://")
+	if len(identifier) == 0 {
+		return "", ErrIdentifierRequired
+	}
+
+	// XRI identifiers are not supported
+	if strings.ContainsAny(identifier[:1], "=@+$!(") {
+		return "", ErrInvalidIdentifier
+	}
+
+	if !strings.HasPrefix(identifier, "http://") && !strings.HasPrefix(identifier, "https://") {
+		identifier = "http://" + identifier
+	}
+
+	u, err := url.Parse(identifier)
+	if err != nil || len(u.Host) == 0 {
+		return "", ErrInvalidIdentifier
+	}
+	u.Fragment = ""
+	if len(u.Path) == 0 {
+		u.Path = "/"
+	}
+	return u.String(), nil
+}
+
+// discoverIdentifier normalizes the User-supplied identifier and returns
+// the highest priority OpenId Provider endpoint discovered for it.
+func discoverIdentifier(identifier string) (*openIdService, error) {
+	normalized, err := normalizeIdentifier(identifier)
+	if err != nil {
+		return nil, err
+	}
+	services, err := discover(normalized)
+	if err != nil {
+		return nil, err
+	}
+	return services[0], nil
+}
+
+// discover performs Yadis discovery on the normalized identifier, falling
+// back to HTML-based discovery, and returns the OpenId Provider endpoints
+// ordered by priority.
+//
+// See http://openid.net/specs/openid-authentication-2_0.html#discovery
+func discover(identifier string) ([]*openIdService, error) {
+	req, err := http.NewRequest("GET", identifier, nil)
+	if err != nil || checkDiscoveryURL(req.URL) != nil {
+		return nil, ErrInvalidIdentifier
+	}
+	req.Header.Set("Accept", "application/xrds+xml, text/html;q=0.9")
+
+	resp, err := discoveryClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(io.LimitReader(resp.Body, discoveryMaxBytes))
+	if err != nil {
+		return nil, err
+	}
+
+	// the Claimed Identifier is the final URL, after following redirects
+	claimedId := resp.Request.URL
+	claimedId.Fragment = ""
+
+	// the document is an XRDS document
+	if strings.HasPrefix(resp.Header.Get("Content-Type"), "application/xrds+xml") {
+		return parseXRDS(body, claimedId.String())
+	}
+
+	// the document refers to an XRDS document
+	location := resp.Header.Get("X-XRDS-Location")
+	if len(location) == 0 {
+		location = htmlMeta(body, "x-xrds-location")
+	}
+	if len(location) > 0 {
+		services, err := discoverXRDS(location, claimedId.String())
+		if err == nil {
+			return services, nil
+		}
+	}
+
+	// fall back to HTML-based discovery
+	endpoint := htmlLink(body, "openid2.provider")
+	if len(endpoint) == 0 {
+		return nil, ErrDiscoveryFailed
+	}
+	service := &openIdService{
+		Endpoint:  endpoint,
+		ClaimedId: claimedId.String(),
+		LocalId:   htmlLink(body, "openid2.local_id"),
+	}
+	return []*openIdService{service}, nil
+}
+
+// discoverXRDS retrieves and parses the XRDS document at the location.
+func discoverXRDS(location, claimedId string) ([]*openIdService, error) {
+	u, err := url.Parse(location)
+	if err != nil || checkDiscoveryURL(u) != nil {
+		return nil, ErrDiscoveryFailed
+	}
+	resp, err := discoveryClient.Get(u.String())
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(io.LimitReader(resp.Body, discoveryMaxBytes))
+	if err != nil {
+		return nil, err
+	}
+	return parseXRDS(body, claimedId)
+}
+
+// parseXRDS returns the OpenId 2.0 services listed in the final XRD of
+// the XRDS document, ordered by priority. OP Identifier services are
+// listed before Claimed Identifier services.
+func parseXRDS(body []byte, claimedId string) ([]*openIdService, error) {
+	doc := xrdsDocument{}
+	if err := xml.Unmarshal(body, &doc); err != nil || len(doc.XRD) == 0 {
+		return nil, ErrDiscoveryFailed
+	}
+
+	entries := doc.XRD[len(doc.XRD)-1].Service
+	sort.SliceStable(entries, func(i, j int) bool {
+		return entries[i].priority() < entries[j].priority()
+	})
+
+	servers := []*openIdService{}
+	signons := []*openIdService{}
+	for _, entry := range entries {
+		for _, typ := range entry.Type {
+			for _, uri := range entry.URI {
+				uri = strings.TrimSpace(uri)
+				switch strings.TrimSpace(typ) {
+				case openIdServerType:
+					servers = append(servers, &openIdService{Endpoint: uri})
+				case openIdSignonType:
+					signons = append(signons, &openIdService{
+						Endpoint:  uri,
+						ClaimedId: claimedId,
+						LocalId:   strings.TrimSpace(entry.LocalID),
+					})
+				}
+			}
+		}
+	}
+
+	services := append(servers, signons...)
+	if len(services) == 0 {
+		return nil, ErrDiscoveryFailed
+	}
+	return services, nil
+}
+
+// verifyClaimedId performs discovery on the asserted Claimed Identifier,
+// and checks that the asserting OpenId Provider is authorized to make
+// assertions about it.
+//
+// See http://openid.net/specs/openid-authentication-2_0.html#verify_disco
+func verifyClaimedId(params url.Values) error {
+	claimedId, err := url.Parse(params.Get("openid.claimed_id"))
+	if err != nil || len(claimedId.Host) == 0 {
+		return ErrClaimedIdMismatch
+	}
+	claimedId.Fragment = ""
+
+	services, err := discover(claimedId.String())
+	if err != nil {
+		return ErrClaimedIdMismatch
+	}
+
+	for _, service := range services {
+		if len(service.ClaimedId) > 0 &&
+			service.ClaimedId == claimedId.String() &&
+			service.Endpoint == params.Get("openid.op_endpoint") &&
+			service.Identity() == params.Get("openid.identity") {
+			return nil
+		}
+	}
+	return ErrClaimedIdMismatch
+}
+
+// htmlLink returns the href of the first link element in the document with
+// the specified rel value.
+func htmlLink(body []byte, rel string) string {
+	for _, tag := range htmlLinkTag.FindAll(body, -1) {
+		attrs := htmlAttrs(tag)
+		for _, value := range strings.Fields(strings.ToLower(attrs["rel"])) {
+			if value == rel {
+				return attrs["href"]
+			}
+		}
+	}
+	return ""
+}
+
+// htmlMeta returns the content of the first meta element in the document
+// with the specified http-equiv value.
+func htmlMeta(body []byte, httpEquiv string) string {
+	for _, tag := range htmlMetaTag.FindAll(body, -1) {
+		attrs := htmlAttrs(tag)
+		if strings.ToLower(attrs["http-equiv"]) == httpEquiv {
+			return attrs["content"]
+		}
+	}
+	return ""
+}
+
+// htmlAttrs returns the attributes of an HTML tag, keyed by lowercase name.
+func htmlAttrs(tag []byte) map[string]string {
+	attrs := map[string]string{}
+	for _, match := range htmlAttrExpr.FindAllSubmatch(tag, -1) {
+		value := string(match[2]) + string(match[3]) + string(match[4])
+		attrs[strings.ToLower(string(match[1]))] = html.UnescapeString(value)
+	}
+	return attrs
+}
@@ -0,0 +1,78 @@
+package auth
+
+import (
+	"sync"
+	"time"
+)
+
+// Maximum age of an openid.response_nonce. Assertions with an older nonce
+// are rejected, so nonces only need to be remembered for this duration.
+const openIdNonceMaxAge = time.Minute * 5
+
+// A NonceStore is used by the OpenIdProvider to record the response nonces
+// of verified assertions, in order to prevent replay attacks. Applications
+// running multiple instances should provide a shared implementation.
+type NonceStore interface {
+
+	// Accept records the nonce issued by the OpenId Provider endpoint. It
+	// returns false if the nonce has already been recorded.
+	Accept(endpoint, nonce string) bool
+}
+
+// MemoryNonceStore is an in-memory implementation of NonceStore, suitable
+// for applications running a single instance.
+type MemoryNonceStore struct {
+	sync.Mutex
+	nonces map[string]time.Time
+}
+
+// NewMemoryNonceStore allocates and returns a new MemoryNonceStore.
+func NewMemoryNonceStore() *MemoryNonceStore {
+	return &MemoryNonceStore{nonces: map[string]time.Time{}}
+}
+
+// Accept records the nonce, returning false if the nonce has already been
+// recorded, or the nonce timestamp is outside the allowed window.
+func (self *MemoryNonceStore) Accept(endpoint, nonce string) bool {
+	issued, ok := parseNonceTime(nonce)
+	if !ok {
+		return false
+	}
+
+	self.Lock()
+	defer self.Unlock()
+
+	//remove nonces that are too old to be replayed
+	now := time.Now()
+	for key, exp := range self.nonces {
+		if now.After(exp) {
+			delete(self.nonces, key)
+		}
+	}
+
+	key := endpoint + "#" + nonce
+	if _, seen := self.nonces[key]; seen {
+		return false
+	}
+	self.nonces[key] = issued.Add(openIdNonceMaxAge)
+	return true
+}
+
+// parseNonceTime parses the UTC timestamp that prefixes a response nonce
+// (ie 2005-05-15T17:11:51ZUNIQUE), returning false if the nonce is
+// malformed, or if the timestamp is outside the allowed window.
+func parseNonceTime(nonce string) (time.Time, bool) {
+	if len(nonce) < 20 {
+		return time.Time{}, false
+	}
+	issued, err := time.Parse(time.RFC3339, nonce[:20])
+	if err != nil {
+		return time.Time{}, false
+	}
+
+	age := time.Since(issued)
+	if age > openIdNonceMaxAge || age < -openIdNonceMaxAge {
+		return time.Time{}, false
+	}
+	return issued, true
+}
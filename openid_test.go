@@ -0,0 +1,260 @@
+package auth
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"fmt"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"net/url"
+	"testing"
+	"time"
+)
+
+// Test the ability to reject a replayed or expired response nonce.
+func TestMemoryNonceStore(t *testing.T) {
+	store := NewMemoryNonceStore()
+	nonce := time.Now().UTC().Format(time.RFC3339) + "abc"
+
+	if !store.Accept(GoogleOpenIdEndpoint, nonce) {
+		t.Errorf("Expected nonce %v accepted", nonce)
+	}
+	if store.Accept(GoogleOpenIdEndpoint, nonce) {
+		t.Errorf("Expected replayed nonce %v rejected", nonce)
+	}
+
+	expired := time.Now().Add(-time.Hour).UTC().Format(time.RFC3339) + "abc"
+	if store.Accept(GoogleOpenIdEndpoint, expired) {
+		t.Errorf("Expected expired nonce %v rejected", expired)
+	}
+}
+
+// Test the ability to verify the return_to URL against the current request.
+func TestVerifyReturnTo(t *testing.T) {
+	r, _ := http.NewRequest("GET", "/auth/login?openid.mode=id_res&next=1", nil)
+	r.Host = "localhost:8080"
+
+	if err := verifyReturnTo(r, "http://localhost:8080/auth/login?next=1"); err != nil {
+		t.Errorf("Expected return_to verified, got Error %s", err.Error())
+	}
+	if err := verifyReturnTo(r, "http://evil.com/auth/login"); err != ErrReturnToMismatch {
+		t.Errorf("Expected ErrReturnToMismatch for host, got %v", err)
+	}
+	if err := verifyReturnTo(r, "http://localhost:8080/auth/login?next=2"); err != ErrReturnToMismatch {
+		t.Errorf("Expected ErrReturnToMismatch for query, got %v", err)
+	}
+}
+
+// Test the ability to establish a DH-SHA256 association with a Provider, and
+// to verify an assertion signed with the shared MAC key.
+func TestAssociate(t *testing.T) {
+	macKey := []byte("0123456789abcdef0123456789abcdef")
+
+	op := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		r.ParseForm()
+		consumer, _ := base64.StdEncoding.DecodeString(r.Form.Get("openid.dh_consumer_public"))
+
+		// server side of the key exchange
+		private := big.NewInt(123456789)
+		public := new(big.Int).Exp(dhGen, private, dhModulus)
+		shared := new(big.Int).Exp(new(big.Int).SetBytes(consumer), private, dhModulus)
+		digest := sha256.Sum256(btwoc(shared))
+		enc := make([]byte, len(macKey))
+		for i := range macKey {
+			enc[i] = macKey[i] ^ digest[i]
+		}
+
+		fmt.Fprintf(w, "assoc_handle:h1\nassoc_type:%s\nsession_type:%s\nexpires_in:3600\n", AssocHmacSha256, SessionDhSha256)
+		fmt.Fprintf(w, "dh_server_public:%s\nenc_mac_key:%s\n",
+			base64.StdEncoding.EncodeToString(btwoc(public)),
+			base64.StdEncoding.EncodeToString(enc))
+	}))
+	defer op.Close()
+
+	provider := NewOpenIdProvider(op.URL)
+	provider.Associations = NewMemoryAssociationStore()
+	if handle := provider.associationHandle(op.URL); handle != "h1" {
+		t.Fatalf("Expected association handle h1, got %v", handle)
+	}
+
+	assoc, err := provider.Associations.Get(op.URL, "h1")
+	if err != nil || string(assoc.Secret) != string(macKey) {
+		t.Fatalf("Expected MAC key %s, got %v", macKey, assoc)
+	}
+
+	params := url.Values{}
+	params.Set("openid.signed", "op_endpoint,return_to")
+	params.Set("openid.op_endpoint", op.URL)
+	params.Set("openid.return_to", "http://localhost/")
+	mac := hmac.New(sha256.New, macKey)
+	mac.Write([]byte("op_endpoint:" + op.URL + "\nreturn_to:http://localhost/\n"))
+	params.Set("openid.sig", base64.StdEncoding.EncodeToString(mac.Sum(nil)))
+	if err := assoc.Verify(params); err != nil {
+		t.Errorf("Expected signature verified, got Error %s", err.Error())
+	}
+
+	params.Set("openid.return_to", "http://evil.com/")
+	if err := assoc.Verify(params); err != ErrInvalidAssertion {
+		t.Errorf("Expected ErrInvalidAssertion, got %v", err)
+	}
+
+	// an unverified assertion can't invalidate the association
+	params.Set("openid.signed", "op_endpoint,return_to,response_nonce,assoc_handle")
+	params.Set("openid.assoc_handle", "h2")
+	params.Set("openid.invalidate_handle", "h1")
+	if err := provider.verifySignature(op.URL, params); err == nil {
+		t.Errorf("Expected unverified assertion rejected")
+	}
+	if _, err := provider.Associations.Get(op.URL, "h1"); err != nil {
+		t.Errorf("Expected association h1 kept, got Error %s", err.Error())
+	}
+}
+
+// Test the ability to normalize User-supplied identifiers.
+func TestNormalizeIdentifier(t *testing.T) {
+	identifiers := map[string]string{
+		"example.com":               "http://example.com/",
+		" https://me.example.org/ ": "https://me.example.org/",
+		"example.com/user#frag":     "http://example.com/user",
+	}
+	for identifier, expected := range identifiers {
+		if normalized, _ := normalizeIdentifier(identifier); normalized != expected {
+			t.Errorf("Expected identifier %q normalized to %v, got %v", identifier, expected, normalized)
+		}
+	}
+	if _, err := normalizeIdentifier("=example"); err != ErrInvalidIdentifier {
+		t.Errorf("Expected ErrInvalidIdentifier for XRI, got %v", err)
+	}
+}
+
+// Test the ability to refuse discovery of addresses that are not globally
+// reachable, including IPv4 addresses embedded in IPv6 addresses.
+func TestPublicAddress(t *testing.T) {
+	tests := map[string]bool{
+		"93.184.216.34":      true,
+		"2606:2800:220:1::1": true,
+		"64:ff9b::5db8:d822": true,
+		"0.0.0.0":            false,
+		"10.1.2.3":           false,
+		"100.100.100.200":    false,
+		"127.0.0.1":          false,
+		"169.254.169.254":    false,
+		"192.0.0.170":        false,
+		"198.18.0.1":         false,
+		"::1":                false,
+		"::ffff:127.0.0.1":   false,
+		"64:ff9b::a9fe:a9fe": false,
+		"2002:7f00:1::":      false,
+		"fd00:ec2::254":      false,
+		"fe80::1%eth0":       false,
+	}
+	for addr, want := range tests {
+		if got := publicAddress(netip.MustParseAddr(addr)); got != want {
+			t.Errorf("Expected publicAddress(%s) %v, got %v", addr, want, got)
+		}
+	}
+}
+
+// Test the ability to discover OpenId Providers using XRDS and HTML.
+func TestDiscover(t *testing.T) {
+	site := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/xrds":
+			w.Header().Set("Content-Type", "application/xrds+xml")
+			fmt.Fprint(w, `<?xml version="1.0"?>
+<xrds:XRDS xmlns:xrds="xri://$xrds" xmlns="xri://$xrd*($v*2.0)">
+  <XRD>
+    <Service priority="10">
+      <Type>http://specs.openid.net/auth/2.0/signon</Type>
+      <URI>https://op.example.com/signon</URI>
+      <LocalID>https://op.example.com/u/me</LocalID>
+    </Service>
+    <Service priority="0">
+      <Type>http://specs.openid.net/auth/2.0/server</Type>
+      <URI>https://op.example.com/server</URI>
+    </Service>
+  </XRD>
+</xrds:XRDS>`)
+		default:
+			fmt.Fprint(w, `<html><head>
+<link rel="openid2.provider" href="https://op.example.com/auth?a=1&amp;b=2">
+<link rel='openid2.local_id' href='https://op.example.com/u/me'>
+</head></html>`)
+		}
+	}))
+	defer site.Close()
+
+	// the test server listens on a loopback address, which is refused
+	// by the discoveryClient
+	if _, err := discover(site.URL + "/xrds"); err == nil {
+		t.Errorf("Expected discovery of a loopback address refused")
+	}
+	if _, err := discover("file:///etc/passwd"); err != ErrInvalidIdentifier {
+		t.Errorf("Expected ErrInvalidIdentifier for a file url, got %v", err)
+	}
+	client := discoveryClient
+	discoveryClient = http.DefaultClient
+	defer func() { discoveryClient = client }()
+
+	services, err := discover(site.URL + "/xrds")
+	if err != nil || len(services) != 2 {
+		t.Fatalf("Expected 2 XRDS services, got %v %v", services, err)
+	}
+	if services[0].Endpoint != "https://op.example.com/server" || len(services[0].ClaimedId) != 0 {
+		t.Errorf("Expected OP Identifier service first, got %v", services[0])
+	}
+	if services[1].Identity() != "https://op.example.com/u/me" {
+		t.Errorf("Expected LocalID https://op.example.com/u/me, got %v", services[1].Identity())
+	}
+
+	services, err = discover(site.URL + "/html")
+	if err != nil || len(services) != 1 {
+		t.Fatalf("Expected 1 HTML service, got %v %v", services, err)
+	}
+	if services[0].Endpoint != "https://op.example.com/auth?a=1&b=2" {
+		t.Errorf("Expected HTML endpoint https://op.example.com/auth?a=1&b=2, got %v", services[0].Endpoint)
+	}
+	if services[0].ClaimedId != site.URL+"/html" {
+		t.Errorf("Expected Claimed Identifier %v, got %v", site.URL+"/html", services[0].ClaimedId)
+	}
+}
+
+// Test the ability to reject an assertion from the configured endpoint that
+// does not include a signed email address.
+func TestOpenIdAssertionEmail(t *testing.T) {
+	op := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, "is_valid:true\n")
+	}))
+	defer op.Close()
+	provider := NewOpenIdProvider(op.URL)
+
+	// assertion returns the callback request for an assertion, signing
+	// the email address if it is not empty
+	assertion := func(email string) *http.Request {
+		params := url.Values{}
+		params.Set("openid.mode", "id_res")
+		params.Set("openid.op_endpoint", op.URL)
+		params.Set("openid.return_to", "http://localhost/auth/login")
+		params.Set("openid.response_nonce", time.Now().UTC().Format("2006-01-02T15:04:05Z")+email)
+		params.Set("openid.assoc_handle", "h1")
+		params.Set("openid.signed", "op_endpoint,return_to,response_nonce,assoc_handle")
+		if len(email) > 0 {
+			params.Set("openid.ns.ext1", openIdAxNamespace)
+			params.Set("openid.ext1.value.email", email)
+			params.Set("openid.signed", params.Get("openid.signed")+",ext1.value.email")
+		}
+		r, _ := http.NewRequest("GET", "http://localhost/auth/login?"+params.Encode(), nil)
+		return r
+	}
+
+	if _, _, err := provider.GetAuthenticatedUser(httptest.NewRecorder(), assertion("")); err != ErrInvalidAssertion {
+		t.Errorf("Expected ErrInvalidAssertion without an email, got %v", err)
+	}
+	u, _, err := provider.GetAuthenticatedUser(httptest.NewRecorder(), assertion("jdoe@example.com"))
+	if err != nil || u.Id() != "jdoe@example.com" {
+		t.Errorf("Expected User jdoe@example.com, got %v", err)
+	}
+}
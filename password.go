@@ -0,0 +1,151 @@
+package auth
+
+import (
+	"errors"
+	"html/template"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+// ErrInvalidFormToken is returned when a login form is posted without the
+// token issued with the form, which protects against cross-site request
+// forgery.
+var ErrInvalidFormToken = errors.New("Invalid or expired login form, please try again")
+
+// A PasswordVerifier verifies the username and password posted to a login
+// form, and returns the User.
+type PasswordVerifier interface {
+
+	// Verify returns the User if the password is valid for the username,
+	// otherwise ErrInvalidCredentials. To avoid revealing which usernames
+	// exist, an implementation should take the same amount of time to
+	// reject an unknown username as an incorrect password.
+	Verify(username, password string) (User, error)
+}
+
+// PasswordVerifierFunc is an adapter that allows an ordinary function to be
+// used as a PasswordVerifier.
+type PasswordVerifierFunc func(username, password string) (User, error)
+
+func (f PasswordVerifierFunc) Verify(username, password string) (User, error) {
+	return f(username, password)
+}
+
+// PasswordForm holds the data used to render the login form. The form must
+// post the Token in a hidden field named "token", along with the "username"
+// and "password" fields.
+type PasswordForm struct {
+	Action string
+	Token  string
+	Error  string
+}
+
+// DefaultPasswordTemplate is the login form rendered by a PasswordProvider
+// when a Template is not specified.
+var DefaultPasswordTemplate = template.Must(template.New("login").Parse(`<!DOCTYPE html>
+<html>
+<head><title>Sign in</title></head>
+<body>
+<form method="POST" action="{{.Action}}">
+{{if .Error}}<p>{{.Error}}</p>{{end}}
+<input type="hidden" name="token" value="{{.Token}}">
+<label>Username <input type="text" name="username" autocomplete="username" required></label>
+<label>Password <input type="password" name="password" autocomplete="current-password" required></label>
+<button type="submit">Sign in</button>
+</form>
+</body>
+</html>
+`))
+
+// PasswordProvider is an implementation of form-based username and password
+// authentication, for users that cannot use a third-party identity provider.
+// A GET request renders the login form, and a POST request verifies the
+// credentials using the PasswordVerifier.
+type PasswordProvider struct {
+	Verifier PasswordVerifier
+
+	// Template renders the login form, using a PasswordForm. If nil, the
+	// DefaultPasswordTemplate is used.
+	Template *template.Template
+
+	// FailureDelay is the minimum time taken to respond to a failed login,
+	// so that the response time does not reveal why the login failed, and
+	// to slow down password guessing.
+	FailureDelay time.Duration
+}
+
+// NewPasswordProvider allocates and returns a new PasswordProvider.
+func NewPasswordProvider(verifier PasswordVerifier) *PasswordProvider {
+	return &PasswordProvider{
+		Verifier:     verifier,
+		FailureDelay: time.Second,
+	}
+}
+
+// RedirectRequired returns a boolean value indicating if the login form
+// should be displayed, because the credentials were not posted.
+func (self *PasswordProvider) RedirectRequired(r *http.Request) bool {
+	return r.Method != "POST"
+}
+
+// Redirect renders the login form.
+func (self *PasswordProvider) Redirect(w http.ResponseWriter, r *http.Request) {
+	self.render(w, r, http.StatusOK, "")
+}
+
+// GetAuthenticatedUser will verify the form token, and the username and
+// password posted to the login form, and return the User.
+func (self *PasswordProvider) GetAuthenticatedUser(w http.ResponseWriter, r *http.Request) (User, Token, error) {
+	start := time.Now()
+
+	// the form token is verified before the credentials, so a forged
+	// request can't be used to guess passwords
+	if _, err := getStateCookie(w, r, r.PostFormValue("token")); err != nil {
+		return nil, nil, ErrInvalidFormToken
+	}
+
+	u, err := self.Verifier.Verify(r.PostFormValue("username"), r.PostFormValue("password"))
+	if err != nil {
+		time.Sleep(self.FailureDelay - time.Since(start))
+		return nil, nil, err
+	}
+	return u, nil, nil
+}
+
+// Failure renders the login form again, with the error message. It can be
+// used as the AuthHandler's Failure func.
+func (self *PasswordProvider) Failure(w http.ResponseWriter, r *http.Request, err error) {
+	self.render(w, r, http.StatusForbidden, err.Error())
+}
+
+// render writes the login form, with a new single-use form token. The token
+// is persisted in a short-lived, signed cookie and must be posted with the
+// form.
+func (self *PasswordProvider) render(w http.ResponseWriter, r *http.Request, status int, message string) {
+	token := randomString(32)
+	if err := setStateCookie(w, r, token, url.Values{}); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	// the form is posted with the url to return the user to, so that it
+	// is saved again if the login fails
+	action := r.URL.Path
+	if next := authenticatorFor(r).config.nextURL(r); len(next) > 0 {
+		action += "?" + url.Values{nextParam: {next}}.Encode()
+	}
+
+	tmpl := self.Template
+	if tmpl == nil {
+		tmpl = DefaultPasswordTemplate
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(status)
+	tmpl.Execute(w, &PasswordForm{
+		Action: action,
+		Token:  token,
+		Error:  message,
+	})
+}
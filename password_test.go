@@ -0,0 +1,72 @@
+package auth
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+)
+
+// Test the ability to login using a form, protected by a single-use form
+// token.
+func TestPasswordProvider(t *testing.T) {
+	verifier := PasswordVerifierFunc(func(username, password string) (User, error) {
+		if username != "alice" || password != "secret" {
+			return nil, ErrInvalidCredentials
+		}
+		return &user{id: username, provider: "password", name: username}, nil
+	})
+	handler := Password(verifier)
+	handler.provider.(*PasswordProvider).FailureDelay = time.Millisecond * 50
+
+	// login posts the form, using the token and cookie issued with the form
+	login := func(username, password string, withToken bool) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		r, _ := http.NewRequest("GET", "/auth/login", nil)
+		handler.ServeHTTP(w, r)
+		token := regexp.MustCompile(`name="token" value="([^"]+)"`).FindStringSubmatch(w.Body.String())
+		if w.Code != http.StatusOK || token == nil {
+			t.Fatalf("Expected login form with a token, got %d", w.Code)
+		}
+
+		form := url.Values{"username": {username}, "password": {password}}
+		if withToken {
+			form.Set("token", token[1])
+		}
+		r, _ = http.NewRequest("POST", "/auth/login", strings.NewReader(form.Encode()))
+		r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		for _, cookie := range w.Result().Cookies() {
+			r.AddCookie(cookie)
+		}
+		w = httptest.NewRecorder()
+		handler.ServeHTTP(w, r)
+		return w
+	}
+
+	if w := login("alice", "secret", false); w.Code != http.StatusForbidden || !strings.Contains(w.Body.String(), ErrInvalidFormToken.Error()) {
+		t.Errorf("Expected form without a token rejected, got %d", w.Code)
+	}
+
+	start := time.Now()
+	if w := login("alice", "wrong", true); w.Code != http.StatusForbidden || !strings.Contains(w.Body.String(), ErrInvalidCredentials.Error()) {
+		t.Errorf("Expected login form displayed again with the error, got %d", w.Code)
+	}
+	if elapsed := time.Since(start); elapsed < time.Millisecond*50 {
+		t.Errorf("Expected failed login delayed by at least 50ms, got %s", elapsed)
+	}
+
+	w := login("alice", "secret", true)
+	if w.Code != http.StatusSeeOther {
+		t.Fatalf("Expected redirect after login, got %d", w.Code)
+	}
+	r, _ := http.NewRequest("GET", "/", nil)
+	for _, cookie := range w.Result().Cookies() {
+		r.AddCookie(cookie)
+	}
+	if u, err := Config.getSessionUser(httptest.NewRecorder(), r); err != nil || u.Id() != "alice" {
+		t.Errorf("Expected session for alice, got %v", err)
+	}
+}
@@ -0,0 +1,111 @@
+package auth
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// Name of the query parameter that holds the URL a user was trying to reach
+// before they were sent to the login page.
+const nextParam = "next"
+
+// nextKey is the context key of the URL a user is returned to after login.
+type nextKey struct{}
+
+// loginURL returns the LoginRedirect url, including the URL of the request
+// in the next parameter so the user can be returned to it after login. Only
+// GET requests are included, since other requests cannot be repeated by a
+// redirect.
+func (self *AuthConfig) loginURL(r *http.Request) string {
+	if r.Method != "GET" && r.Method != "HEAD" {
+		return self.LoginRedirect
+	}
+
+	sep := "?"
+	if strings.Contains(self.LoginRedirect, "?") {
+		sep = "&"
+	}
+	return self.LoginRedirect + sep + nextParam + "=" + url.QueryEscape(r.URL.RequestURI())
+}
+
+// nextURL returns the next parameter of the login request, so that it can
+// be saved with the state of the login flow. Unsafe urls are ignored.
+func (self *AuthConfig) nextURL(r *http.Request) string {
+	next := r.URL.Query().Get(nextParam)
+	if len(next) == 0 || !self.safeRedirect(r, next) {
+		return ""
+	}
+	return next
+}
+
+// withNext returns a copy of the request that can hold the url read from
+// the state of the login flow, using setNext.
+func withNext(r *http.Request) *http.Request {
+	return r.WithContext(context.WithValue(r.Context(), nextKey{}, new(string)))
+}
+
+// setNext saves the url read from the state of the login flow, so that it
+// is available to successURL. Each flow carries its own url, so logins that
+// are started in separate tabs do not overwrite each other.
+func setNext(r *http.Request, next string) {
+	if holder, ok := r.Context().Value(nextKey{}).(*string); ok {
+		*holder = next
+	}
+}
+
+// SuccessURL returns the url the User was trying to reach before logging
+// in, or the Config.LoginSuccessRedirect url. A custom AuthHandler.Success
+// func can use it to return the User to where they were.
+func SuccessURL(r *http.Request) string {
+	return authenticatorFor(r).config.successURL(r)
+}
+
+// successURL returns the url saved by setNext. If there is no saved url, or
+// it is not safe, the LoginSuccessRedirect is returned.
+func (self *AuthConfig) successURL(r *http.Request) string {
+	holder, ok := r.Context().Value(nextKey{}).(*string)
+	if !ok || len(*holder) == 0 || !self.safeRedirect(r, *holder) {
+		return self.LoginSuccessRedirect
+	}
+	return *holder
+}
+
+// safeRedirect returns true if the url is a path on the same host, or an
+// absolute url on the same host or on one of the RedirectHosts. This
+// prevents the login flow from being used as an open redirect.
+func (self *AuthConfig) safeRedirect(r *http.Request, target string) bool {
+	// browsers treat backslashes as slashes, so "/\\evil.com" would be
+	// a protocol-relative url
+	if strings.ContainsAny(target, "\\\r\n\t") {
+		return false
+	}
+	u, err := url.Parse(target)
+	if err != nil {
+		return false
+	}
+
+	// a relative url must be an absolute path, and not a protocol
+	// relative url such as "//evil.com"
+	if len(u.Scheme) == 0 && len(u.Host) == 0 {
+		return strings.HasPrefix(u.Path, "/") && !strings.HasPrefix(target, "//")
+	}
+
+	if u.Scheme != "http" && u.Scheme != "https" || u.User != nil {
+		return false
+	}
+	// a request made over TLS must not be redirected to plain http
+	if r.TLS != nil && u.Scheme != "https" {
+		return false
+	}
+	if strings.EqualFold(u.Host, r.Host) {
+		return true
+	}
+	for _, host := range self.RedirectHosts {
+		if strings.EqualFold(u.Host, host) {
+			return true
+		}
+	}
+	return false
+}
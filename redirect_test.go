@@ -0,0 +1,143 @@
+package auth
+
+import (
+	"crypto/tls"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+)
+
+// Test the ability to reject urls that would redirect the user to another
+// site after login.
+func TestSafeRedirect(t *testing.T) {
+	config := NewConfig()
+	config.RedirectHosts = []string{"app.example.com"}
+	r, _ := http.NewRequest("GET", "http://www.example.com/auth/login", nil)
+
+	tests := map[string]bool{
+		"/private?tab=1":                  true,
+		"http://www.example.com/private":  true,
+		"https://app.example.com/private": true,
+		"//evil.com/private":              false,
+		"/\\evil.com":                     false,
+		"https://evil.com/private":        false,
+		"javascript:alert(1)":             false,
+		"https://user@www.example.com/":   false,
+		"private":                         false,
+	}
+	for target, want := range tests {
+		if got := config.safeRedirect(r, target); got != want {
+			t.Errorf("Expected safeRedirect(%q) %v, got %v", target, want, got)
+		}
+	}
+}
+
+// Test the ability to reject a redirect from a request made over TLS to a
+// plain http url on the same host.
+func TestSafeRedirectDowngrade(t *testing.T) {
+	config := NewConfig()
+	r, _ := http.NewRequest("GET", "https://www.example.com/auth/login", nil)
+	r.TLS = &tls.ConnectionState{}
+
+	if config.safeRedirect(r, "http://www.example.com/private") {
+		t.Errorf("Expected http url rejected for a TLS request")
+	}
+	if !config.safeRedirect(r, "https://www.example.com/private") {
+		t.Errorf("Expected https url accepted for a TLS request")
+	}
+}
+
+// Test the ability to return the user to the url they were trying to reach
+// before logging in, when logins are started in separate tabs.
+func TestLoginReturnURL(t *testing.T) {
+	provider := &testProvider{}
+	handler := New(provider)
+
+	// an unauthenticated request is sent to the login page
+	w := httptest.NewRecorder()
+	r, _ := http.NewRequest("GET", "/private?tab=1", nil)
+	SecureFunc(func(w http.ResponseWriter, r *http.Request) {})(w, r)
+	login := w.Header().Get("Location")
+
+	// the login page redirects to the provider, saving the url with the
+	// state of each flow
+	start := func(login string) (string, *http.Cookie) {
+		w := httptest.NewRecorder()
+		r, _ := http.NewRequest("GET", login, nil)
+		handler.ServeHTTP(w, r)
+		location, _ := url.Parse(w.Header().Get("Location"))
+		return location.Query().Get("state"), w.Result().Cookies()[0]
+	}
+	state1, cookie1 := start(login)
+	state2, cookie2 := start("/auth/login?next=%2Fsettings")
+
+	// the provider's callback returns the user to the url of each flow
+	for _, flow := range []struct {
+		state  string
+		cookie *http.Cookie
+		want   string
+	}{{state2, cookie2, "/settings"}, {state1, cookie1, "/private?tab=1"}} {
+		w = httptest.NewRecorder()
+		r, _ = http.NewRequest("GET", "/auth/login?code=abc&state="+flow.state, nil)
+		r.AddCookie(flow.cookie)
+		handler.ServeHTTP(w, r)
+		if location := w.Header().Get("Location"); location != flow.want {
+			t.Errorf("Expected redirect to %s, got %s", flow.want, location)
+		}
+	}
+
+	// an unsafe url is never saved
+	state, cookie := start("/auth/login?next=https://evil.com/")
+	w = httptest.NewRecorder()
+	r, _ = http.NewRequest("GET", "/auth/login?code=abc&state="+state, nil)
+	r.AddCookie(cookie)
+	handler.ServeHTTP(w, r)
+	if location := w.Header().Get("Location"); location != Config.LoginSuccessRedirect {
+		t.Errorf("Expected unsafe return url ignored, got %s", location)
+	}
+}
+
+// Test the ability to read the return url from a custom Success func.
+func TestSuccessURL(t *testing.T) {
+	var got string
+	handler := New(&testProvider{})
+	handler.Success = func(w http.ResponseWriter, r *http.Request, u User, tok Token) {
+		got = SuccessURL(r)
+	}
+
+	w := httptest.NewRecorder()
+	r, _ := http.NewRequest("GET", "/auth/login?next=%2Fsettings", nil)
+	handler.ServeHTTP(w, r)
+	location, _ := url.Parse(w.Header().Get("Location"))
+	cookie := w.Result().Cookies()[0]
+
+	w = httptest.NewRecorder()
+	r, _ = http.NewRequest("GET", "/auth/login?code=abc&state="+location.Query().Get("state"), nil)
+	r.AddCookie(cookie)
+	handler.ServeHTTP(w, r)
+	if got != "/settings" {
+		t.Errorf("Expected return url /settings, got %s", got)
+	}
+}
+
+// testProvider is an AuthProvider that saves the state of the flow, and
+// authenticates every callback with a valid state.
+type testProvider struct{}
+
+func (self *testProvider) RedirectRequired(r *http.Request) bool {
+	return r.URL.Query().Get("code") == ""
+}
+
+func (self *testProvider) Redirect(w http.ResponseWriter, r *http.Request) {
+	state := randomString(32)
+	setStateCookie(w, r, state, url.Values{})
+	http.Redirect(w, r, "https://provider.example.com/authorize?state="+state, http.StatusSeeOther)
+}
+
+func (self *testProvider) GetAuthenticatedUser(w http.ResponseWriter, r *http.Request) (User, Token, error) {
+	if _, err := getStateCookie(w, r, r.URL.Query().Get("state")); err != nil {
+		return nil, nil, err
+	}
+	return &user{id: "jdoe"}, nil, nil
+}
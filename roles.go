@@ -0,0 +1,181 @@
+package auth
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+)
+
+// ErrForbidden is returned when the authenticated User is not permitted to
+// access the resource.
+var ErrForbidden = errors.New("You do not have permission to access this resource")
+
+// DefaultRolesKey is the key of the session data, or DataUser.Data, that
+// holds the User's comma separated roles.
+const DefaultRolesKey = "roles"
+
+// A RoleResolver returns the roles, or groups, of an authenticated User.
+type RoleResolver interface {
+
+	// Roles returns the User's roles.
+	Roles(u User) ([]string, error)
+}
+
+// RoleResolverFunc is an adapter that allows an ordinary function to be used
+// as a RoleResolver.
+type RoleResolverFunc func(u User) ([]string, error)
+
+func (f RoleResolverFunc) Roles(u User) ([]string, error) {
+	return f(u)
+}
+
+// StaticRoles is a RoleResolver that maps a User's Id to their roles.
+type StaticRoles map[string][]string
+
+func (self StaticRoles) Roles(u User) ([]string, error) {
+	return self[u.Id()], nil
+}
+
+// SessionRoles is a RoleResolver that reads the comma separated roles stored
+// in the User's session data, for example by a DataUser created with
+// NewDataUser when the user logged in.
+type SessionRoles struct {
+	// Key of the session data. If empty, the DefaultRolesKey is used.
+	Key string
+}
+
+func (self SessionRoles) Roles(u User) ([]string, error) {
+	du, ok := u.(DataUser)
+	if !ok {
+		return nil, nil
+	}
+	key := self.Key
+	if len(key) == 0 {
+		key = DefaultRolesKey
+	}
+
+	var roles []string
+	for _, role := range strings.Split(du.Data()[key], ",") {
+		if role = strings.TrimSpace(role); len(role) > 0 {
+			roles = append(roles, role)
+		}
+	}
+	return roles, nil
+}
+
+// A Policy decides whether the authenticated User may access the requested
+// resource.
+type Policy func(r *http.Request, u User) (bool, error)
+
+// HasRole returns a Policy that allows a User with any of the roles, as
+// returned by the Config.RoleResolver.
+func HasRole(roles ...string) Policy {
+	return func(r *http.Request, u User) (bool, error) {
+		userRoles, err := authenticatorFor(r).config.roleResolver().Roles(u)
+		if err != nil {
+			return false, err
+		}
+		for _, role := range roles {
+			for _, userRole := range userRoles {
+				if role == userRole {
+					return true, nil
+				}
+			}
+		}
+		return false, nil
+	}
+}
+
+// AllOf returns a Policy that allows a User permitted by all of the
+// policies.
+func AllOf(policies ...Policy) Policy {
+	return func(r *http.Request, u User) (bool, error) {
+		for _, policy := range policies {
+			if ok, err := policy(r, u); !ok || err != nil {
+				return false, err
+			}
+		}
+		return true, nil
+	}
+}
+
+// Require returns middleware that will attempt to verify a user session
+// exists, and that the User is permitted by the Policy, prior to serving the
+// request with the http.Handler. If no valid session exists, the user will
+// be redirected to the Config.LoginRedirect Url, or an API request will
+// receive a 401 response. A User that is not permitted receives a 403
+// response.
+func Require(policy Policy) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			defaultAuthenticator().Require(policy)(next).ServeHTTP(w, r)
+		})
+	}
+}
+
+// Require returns middleware that will attempt to verify a user session
+// exists, and that the User is permitted by the Policy, using the
+// Authenticator's config.
+func (self *Authenticator) Require(policy Policy) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			user, err := self.config.getSessionUser(w, r)
+
+			//if no active user session then authorize user
+			if err != nil || user.Id() == "" {
+				self.unauthenticated(w, r, err)
+				return
+			}
+
+			r = self.withUser(r, user)
+			ok, err := policy(r, user)
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
+			if !ok {
+				forbidden(w, r)
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// SecureRoles will attempt to verify a user session exists, and that the
+// User has any of the roles, prior to executing the auth.SecureHandlerFunc
+// function. If no valid session exists, the user will be redirected to a
+// login URL. A User without the roles receives a 403 response.
+func SecureRoles(handler SecureHandlerFunc, roles ...string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		defaultAuthenticator().SecureRoles(handler, roles...)(w, r)
+	}
+}
+
+// SecureRoles will attempt to verify a user session exists, and that the
+// User has any of the roles, prior to executing the auth.SecureHandlerFunc
+// function, using the Authenticator's config.
+func (self *Authenticator) SecureRoles(handler SecureHandlerFunc, roles ...string) http.HandlerFunc {
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		handler(w, r, UserFromContext(r.Context()))
+	})
+	return self.Require(HasRole(roles...))(next).ServeHTTP
+}
+
+// forbidden responds to a request from a User that is not permitted to
+// access the resource.
+func forbidden(w http.ResponseWriter, r *http.Request) {
+	if isAPIRequest(r) {
+		writeJSONError(w, http.StatusForbidden, "forbidden", ErrForbidden.Error())
+		return
+	}
+	http.Error(w, ErrForbidden.Error(), http.StatusForbidden)
+}
+
+// roleResolver returns the RoleResolver, defaulting to SessionRoles.
+func (self *AuthConfig) roleResolver() RoleResolver {
+	if self.RoleResolver != nil {
+		return self.RoleResolver
+	}
+	return SessionRoles{}
+}
@@ -0,0 +1,48 @@
+package auth
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// Test the ability to restrict a handler to users with a role.
+func TestSecureRoles(t *testing.T) {
+	Config.CookieSecret = []byte("7H9xiimk2QdTdYI7rDddfJeV")
+	defer func() { Config.RoleResolver = nil }()
+
+	handler := SecureRoles(func(w http.ResponseWriter, r *http.Request, u User) {
+		w.Write([]byte(u.Id()))
+	}, "admin", "finance")
+
+	// request returns the response to a request from the user, or from a
+	// guest if the user is nil
+	request := func(u User) *httptest.ResponseRecorder {
+		r, _ := http.NewRequest("GET", "/admin", nil)
+		if u != nil {
+			w := httptest.NewRecorder()
+			SetUserCookie(w, r, u)
+			r.AddCookie(w.Result().Cookies()[0])
+		}
+		w := httptest.NewRecorder()
+		handler(w, r)
+		return w
+	}
+
+	if w := request(nil); w.Code != http.StatusSeeOther {
+		t.Errorf("Expected guest redirected to login, got %d", w.Code)
+	}
+
+	admin := NewDataUser(&user{id: "1", provider: "github"}, map[string]string{"roles": "staff, admin"})
+	if w := request(admin); w.Code != http.StatusOK || w.Body.String() != "1" {
+		t.Errorf("Expected admin role read from the session, got %d", w.Code)
+	}
+	if w := request(&user{id: "2", provider: "github"}); w.Code != http.StatusForbidden {
+		t.Errorf("Expected 403 for a user without the role, got %d", w.Code)
+	}
+
+	Config.RoleResolver = StaticRoles{"2": {"finance"}}
+	if w := request(&user{id: "2", provider: "github"}); w.Code != http.StatusOK {
+		t.Errorf("Expected finance role from StaticRoles, got %d", w.Code)
+	}
+}
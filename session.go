@@ -0,0 +1,223 @@
+package auth
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// ErrSessionNotFound is returned by a SessionStore when the session does
+// not exist, or has expired.
+var ErrSessionNotFound = errors.New("User session not found")
+
+// A Session is the server-side record of a User's login session. When a
+// SessionStore is configured, the session cookie holds only the Session Id.
+type Session struct {
+	Id       string
+	User     User
+	Created  time.Time // Time the User logged in
+	Accessed time.Time // Time the session was last used
+	Expires  time.Time // Time the session expires
+}
+
+// A SessionStore saves login sessions server-side, so that sessions can be
+// revoked, and so that the User data is not stored in the cookie.
+type SessionStore interface {
+
+	// Create saves a new session.
+	Create(s *Session) error
+
+	// Get returns the session with the specified id. ErrSessionNotFound is
+	// returned if the session does not exist or has expired.
+	Get(id string) (*Session, error)
+
+	// Touch records the time the session was last used.
+	Touch(id string, t time.Time) error
+
+	// Delete removes the session. Deleting a session that does not exist is
+	// not an error.
+	Delete(id string) error
+}
+
+// MemorySessionStore is an in-memory implementation of SessionStore,
+// suitable for applications running a single instance. Sessions are lost
+// when the application restarts.
+type MemorySessionStore struct {
+	sync.Mutex
+	sessions map[string]Session
+}
+
+// NewMemorySessionStore allocates and returns a new MemorySessionStore.
+func NewMemorySessionStore() *MemorySessionStore {
+	return &MemorySessionStore{sessions: map[string]Session{}}
+}
+
+func (self *MemorySessionStore) Create(s *Session) error {
+	self.Lock()
+	defer self.Unlock()
+
+	//remove sessions that have expired
+	now := time.Now()
+	for id, session := range self.sessions {
+		if now.After(session.Expires) {
+			delete(self.sessions, id)
+		}
+	}
+
+	self.sessions[s.Id] = *s
+	return nil
+}
+
+func (self *MemorySessionStore) Get(id string) (*Session, error) {
+	self.Lock()
+	defer self.Unlock()
+
+	session, ok := self.sessions[id]
+	if !ok || time.Now().After(session.Expires) {
+		return nil, ErrSessionNotFound
+	}
+	return &session, nil
+}
+
+func (self *MemorySessionStore) Touch(id string, t time.Time) error {
+	self.Lock()
+	defer self.Unlock()
+
+	session, ok := self.sessions[id]
+	if !ok {
+		return ErrSessionNotFound
+	}
+	session.Accessed = t
+	self.sessions[id] = session
+	return nil
+}
+
+func (self *MemorySessionStore) Delete(id string) error {
+	self.Lock()
+	defer self.Unlock()
+
+	delete(self.sessions, id)
+	return nil
+}
+
+// newSession creates and saves a new session for the User, with a random
+// session id. The session is stored until the absolute timeout, and the idle
+// timeout is enforced using the time it was last accessed.
+func (self *AuthConfig) newSession(u User, created time.Time) (*Session, error) {
+	session := Session{
+		Id:       randomString(32),
+		User:     u,
+		Created:  created,
+		Accessed: created,
+		Expires:  created.Add(self.maxLifetime()),
+	}
+	if err := self.SessionStore.Create(&session); err != nil {
+		return nil, err
+	}
+	return &session, nil
+}
+
+// getSession returns the User and login time for the specified session id,
+// and records that the session was used. The session expires if it has not
+// been used within the idle timeout.
+func (self *AuthConfig) getSession(id string) (User, time.Time, error) {
+	session, err := self.SessionStore.Get(id)
+	if err != nil {
+		return nil, time.Time{}, err
+	}
+	now := time.Now()
+	if now.After(session.Expires) || now.After(self.sessionExpiry(session.Created, session.Accessed)) {
+		self.SessionStore.Delete(id)
+		return nil, time.Time{}, ErrSessionExpired
+	}
+
+	// the session is valid, so a failure to record the access time must
+	// not log the user out, unless the session was deleted meanwhile
+	switch err := self.SessionStore.Touch(id, now); {
+	case err == ErrSessionNotFound:
+		return nil, time.Time{}, err
+	case err != nil:
+		log.Printf("auth: unable to record access of session: %s", err)
+	}
+	return session.User, session.Created, nil
+}
+
+// deleteSession removes the session referenced by the request's session
+// cookie, if a SessionStore is configured.
+func (self *AuthConfig) deleteSession(r *http.Request) {
+	if self.SessionStore == nil {
+		return
+	}
+	id, _, err := self.readCookie(r, self.CookieName)
+	if err != nil {
+		return
+	}
+	self.SessionStore.Delete(id)
+}
+
+// sessionRecord is the JSON representation of a Session, used by the
+// SessionStore implementations that serialize sessions.
+type sessionRecord struct {
+	Id       string            `json:"id"`
+	UserId   string            `json:"user_id"`
+	Provider string            `json:"provider"`
+	Name     string            `json:"name"`
+	Email    string            `json:"email"`
+	Org      string            `json:"org"`
+	Link     string            `json:"link"`
+	Picture  string            `json:"picture"`
+	Data     map[string]string `json:"data,omitempty"`
+	Created  time.Time         `json:"created"`
+	Accessed time.Time         `json:"accessed"`
+	Expires  time.Time         `json:"expires"`
+}
+
+// marshalSession returns the JSON encoding of the Session.
+func marshalSession(s *Session) ([]byte, error) {
+	var data map[string]string
+	if du, ok := s.User.(DataUser); ok {
+		data = du.Data()
+	}
+	return json.Marshal(&sessionRecord{
+		Id:       s.Id,
+		UserId:   s.User.Id(),
+		Provider: s.User.Provider(),
+		Name:     s.User.Name(),
+		Email:    s.User.Email(),
+		Org:      s.User.Org(),
+		Link:     s.User.Link(),
+		Picture:  s.User.Picture(),
+		Data:     data,
+		Created:  s.Created,
+		Accessed: s.Accessed,
+		Expires:  s.Expires,
+	})
+}
+
+// unmarshalSession parses a Session from its JSON encoding.
+func unmarshalSession(data []byte) (*Session, error) {
+	rec := sessionRecord{}
+	if err := json.Unmarshal(data, &rec); err != nil {
+		return nil, err
+	}
+	u := user{
+		id:       rec.UserId,
+		provider: rec.Provider,
+		name:     rec.Name,
+		email:    rec.Email,
+		org:      rec.Org,
+		link:     rec.Link,
+		picture:  rec.Picture,
+		data:     rec.Data,
+	}
+	return &Session{
+		Id:       rec.Id,
+		User:     &u,
+		Created:  rec.Created,
+		Accessed: rec.Accessed,
+		Expires:  rec.Expires,
+	}, nil
+}
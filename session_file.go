@@ -0,0 +1,158 @@
+package auth
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// FileSessionStore is an implementation of SessionStore that saves each
+// session as a JSON file in a directory. Sessions survive application
+// restarts, but the directory should not be shared between hosts.
+type FileSessionStore struct {
+	Dir string
+}
+
+// NewFileSessionStore allocates and returns a new FileSessionStore, creating
+// the directory if it does not exist.
+func NewFileSessionStore(dir string) (*FileSessionStore, error) {
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, err
+	}
+	return &FileSessionStore{dir}, nil
+}
+
+func (self *FileSessionStore) Create(s *Session) error {
+	path, ok := self.path(s.Id)
+	if !ok {
+		return ErrSessionNotFound
+	}
+	data, err := marshalSession(s)
+	if err != nil {
+		return err
+	}
+
+	// write to a unique temporary file, so a session is never partially
+	// written, and concurrent writes of the same session don't collide
+	tmp, err := ioutil.TempFile(self.Dir, s.Id+".*.tmp")
+	if err != nil {
+		return err
+	}
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	return nil
+}
+
+func (self *FileSessionStore) Get(id string) (*Session, error) {
+	path, ok := self.path(id)
+	if !ok {
+		return nil, ErrSessionNotFound
+	}
+	f, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return nil, ErrSessionNotFound
+	} else if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	data, err := ioutil.ReadAll(f)
+	if err != nil {
+		return nil, err
+	}
+	info, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+
+	session, err := unmarshalSession(data)
+	if err != nil {
+		return nil, err
+	}
+
+	// the time the session was last accessed is recorded by Touch as the
+	// file's modification time
+	if info.ModTime().After(session.Accessed) {
+		session.Accessed = info.ModTime()
+	}
+	if time.Now().After(session.Expires) {
+		os.Remove(path)
+		return nil, ErrSessionNotFound
+	}
+	return session, nil
+}
+
+// Touch records the access time as the modification time of the session's
+// file. The file is not re-written, so a session that is deleted while it is
+// touched can't be restored.
+func (self *FileSessionStore) Touch(id string, t time.Time) error {
+	path, ok := self.path(id)
+	if !ok {
+		return ErrSessionNotFound
+	}
+	err := os.Chtimes(path, t, t)
+	if os.IsNotExist(err) {
+		return ErrSessionNotFound
+	}
+	return err
+}
+
+func (self *FileSessionStore) Delete(id string) error {
+	path, ok := self.path(id)
+	if !ok {
+		return nil
+	}
+	if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+// Prune removes the files of expired sessions. It should be called
+// periodically, since expired sessions are otherwise only removed when
+// they are read.
+func (self *FileSessionStore) Prune() error {
+	files, err := filepath.Glob(filepath.Join(self.Dir, "*.json"))
+	if err != nil {
+		return err
+	}
+	now := time.Now()
+	for _, file := range files {
+		data, err := ioutil.ReadFile(file)
+		if err != nil {
+			continue
+		}
+		if session, err := unmarshalSession(data); err != nil || now.After(session.Expires) {
+			os.Remove(file)
+		}
+	}
+	return nil
+}
+
+// path returns the file path for the session id. The id must only contain
+// the characters generated by randomString, so that it cannot be used to
+// access files outside of the directory.
+func (self *FileSessionStore) path(id string) (string, bool) {
+	if len(id) == 0 {
+		return "", false
+	}
+	for _, c := range id {
+		switch {
+		case c >= 'a' && c <= 'z', c >= 'A' && c <= 'Z', c >= '0' && c <= '9', c == '-', c == '_':
+		default:
+			return "", false
+		}
+	}
+	return filepath.Join(self.Dir, id+".json"), true
+}
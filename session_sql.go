@@ -0,0 +1,96 @@
+package auth
+
+import (
+	"database/sql"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// SQLSessionStore is an implementation of SessionStore using a database/sql
+// database, allowing sessions to be shared by multiple application
+// instances. The table must be created before use, for example:
+//
+//	CREATE TABLE sessions (
+//	    id       VARCHAR(64) PRIMARY KEY,
+//	    data     TEXT    NOT NULL,
+//	    accessed INTEGER NOT NULL,
+//	    expires  INTEGER NOT NULL
+//	);
+type SQLSessionStore struct {
+	DB    *sql.DB
+	Table string
+
+	// Numbered uses numbered query placeholders ($1, $2) instead of the
+	// question mark placeholders, as required by PostgreSQL.
+	Numbered bool
+}
+
+// NewSQLSessionStore allocates and returns a new SQLSessionStore, using the
+// specified database table.
+func NewSQLSessionStore(db *sql.DB, table string) *SQLSessionStore {
+	return &SQLSessionStore{DB: db, Table: table}
+}
+
+func (self *SQLSessionStore) Create(s *Session) error {
+	data, err := marshalSession(s)
+	if err != nil {
+		return err
+	}
+	_, err = self.DB.Exec(self.query("INSERT INTO %s (id, data, accessed, expires) VALUES (?, ?, ?, ?)"),
+		s.Id, string(data), s.Accessed.Unix(), s.Expires.Unix())
+	return err
+}
+
+func (self *SQLSessionStore) Get(id string) (*Session, error) {
+	var data string
+	var accessed int64
+	err := self.DB.QueryRow(self.query("SELECT data, accessed FROM %s WHERE id = ? AND expires > ?"),
+		id, time.Now().Unix()).Scan(&data, &accessed)
+	if err == sql.ErrNoRows {
+		return nil, ErrSessionNotFound
+	} else if err != nil {
+		return nil, err
+	}
+
+	session, err := unmarshalSession([]byte(data))
+	if err != nil {
+		return nil, err
+	}
+	session.Accessed = time.Unix(accessed, 0)
+	return session, nil
+}
+
+// Touch records the time the session was last used. The number of rows
+// affected is not checked, since MySQL reports zero rows when the value is
+// unchanged, and Get already rejects sessions that don't exist.
+func (self *SQLSessionStore) Touch(id string, t time.Time) error {
+	_, err := self.DB.Exec(self.query("UPDATE %s SET accessed = ? WHERE id = ?"), t.Unix(), id)
+	return err
+}
+
+func (self *SQLSessionStore) Delete(id string) error {
+	_, err := self.DB.Exec(self.query("DELETE FROM %s WHERE id = ?"), id)
+	return err
+}
+
+// Prune removes expired sessions from the table. It should be called
+// periodically.
+func (self *SQLSessionStore) Prune() error {
+	_, err := self.DB.Exec(self.query("DELETE FROM %s WHERE expires <= ?"), time.Now().Unix())
+	return err
+}
+
+// query inserts the table name, and rewrites the placeholders if Numbered
+// is enabled.
+func (self *SQLSessionStore) query(format string) string {
+	query := fmt.Sprintf(format, self.Table)
+	if !self.Numbered {
+		return query
+	}
+	parts := strings.Split(query, "?")
+	for i := 1; i < len(parts); i++ {
+		parts[i] = fmt.Sprintf("$%d", i) + parts[i]
+	}
+	return strings.Join(parts, "")
+}
@@ -0,0 +1,197 @@
+package auth
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// Test the ability to store the session server-side, rotate the session id
+// on login, and delete the session on logout.
+func TestSessionStore(t *testing.T) {
+	t.Parallel()
+	store := NewMemorySessionStore()
+	config := NewConfig()
+	config.CookieSecret = []byte("7H9xiimk2QdTdYI7rDddfJeV")
+	config.SessionStore = store
+	a := NewAuthenticator(config)
+
+	login := func(r *http.Request) *http.Cookie {
+		w := httptest.NewRecorder()
+		if err := a.SetUserCookie(w, r, &user{id: "jdoe", email: "jdoe@example.com"}); err != nil {
+			t.Fatalf("Expected cookie set, got Error %s", err.Error())
+		}
+		return w.Result().Cookies()[0]
+	}
+
+	r, _ := http.NewRequest("GET", "/", nil)
+	cookie := login(r)
+
+	id, _, err := config.codec().Decode(cookie.Name, cookie.Value)
+	if err != nil {
+		t.Fatalf("Expected cookie decoded, got Error %s", err.Error())
+	}
+	if _, err := store.Get(id); err != nil {
+		t.Errorf("Expected session stored, got Error %s", err.Error())
+	}
+
+	r, _ = http.NewRequest("GET", "/", nil)
+	r.AddCookie(cookie)
+	u, err := a.GetUserCookie(r)
+	if err != nil {
+		t.Fatalf("Expected User from session, got Error %s", err.Error())
+	}
+	if u.Email() != "jdoe@example.com" {
+		t.Errorf("Expected jdoe@example.com, got %s", u.Email())
+	}
+
+	// logging in again replaces the session
+	rotated := login(r)
+	if rotated.Value == cookie.Value {
+		t.Errorf("Expected session id rotated on login")
+	}
+	if _, err := store.Get(id); err != ErrSessionNotFound {
+		t.Errorf("Expected ErrSessionNotFound for previous session, got %v", err)
+	}
+
+	// logging out deletes the session
+	r, _ = http.NewRequest("GET", "/", nil)
+	r.AddCookie(rotated)
+	a.DeleteUserCookie(httptest.NewRecorder(), r)
+	if _, err := a.GetUserCookie(r); err != ErrSessionNotFound {
+		t.Errorf("Expected ErrSessionNotFound after logout, got %v", err)
+	}
+}
+
+// Test the ability to save sessions to files, and reject ids that are not
+// valid file names.
+func TestFileSessionStore(t *testing.T) {
+	store, err := NewFileSessionStore(t.TempDir())
+	if err != nil {
+		t.Fatalf("Expected store created, got Error %s", err.Error())
+	}
+
+	session := Session{Id: "abc-123_XYZ", User: &user{id: "jdoe"}, Expires: time.Now().Add(time.Hour)}
+	if err := store.Create(&session); err != nil {
+		t.Fatalf("Expected session created, got Error %s", err.Error())
+	}
+	got, err := store.Get(session.Id)
+	if err != nil {
+		t.Fatalf("Expected session, got Error %s", err.Error())
+	}
+	if got.User.Id() != "jdoe" {
+		t.Errorf("Expected jdoe, got %s", got.User.Id())
+	}
+
+	if _, err := store.Get("../abc-123_XYZ"); err != ErrSessionNotFound {
+		t.Errorf("Expected ErrSessionNotFound for invalid id, got %v", err)
+	}
+	// concurrent requests touch the same session
+	errs := make(chan error, 20)
+	for i := 0; i < cap(errs); i++ {
+		go func() { errs <- store.Touch(session.Id, time.Now()) }()
+	}
+	for i := 0; i < cap(errs); i++ {
+		if err := <-errs; err != nil {
+			t.Errorf("Expected concurrent Touch to succeed, got Error %s", err.Error())
+		}
+	}
+
+	accessed := time.Now().Add(time.Minute).Truncate(time.Second)
+	store.Touch(session.Id, accessed)
+	if got, _ := store.Get(session.Id); got == nil || !got.Accessed.Equal(accessed) {
+		t.Errorf("Expected session accessed at %s, got %v", accessed, got)
+	}
+
+	// a deleted session can't be restored by Touch
+	store.Delete(session.Id)
+	if err := store.Touch(session.Id, time.Now()); err != ErrSessionNotFound {
+		t.Errorf("Expected ErrSessionNotFound touching a deleted session, got %v", err)
+	}
+	if _, err := store.Get(session.Id); err != ErrSessionNotFound {
+		t.Errorf("Expected deleted session not found, got %v", err)
+	}
+}
+
+// Test the ability to extend a session near the idle timeout, without
+// extending it past the absolute timeout.
+func TestSessionTimeouts(t *testing.T) {
+	t.Parallel()
+	config := NewConfig()
+	config.CookieSecret = []byte("7H9xiimk2QdTdYI7rDddfJeV")
+	config.SessionIdleTimeout = time.Minute * 10
+	config.SessionMaxLifetime = time.Hour
+
+	request := func(created, exp time.Time) (*http.Request, *httptest.ResponseRecorder) {
+		w := httptest.NewRecorder()
+		config.setUserCookie(w, config.newUserCookie(), &user{id: "jdoe"}, created, exp)
+		r, _ := http.NewRequest("GET", "/", nil)
+		r.AddCookie(w.Result().Cookies()[0])
+		return r, httptest.NewRecorder()
+	}
+	expires := func(w *httptest.ResponseRecorder) time.Time {
+		cookies := w.Result().Cookies()
+		if len(cookies) == 0 {
+			return time.Time{}
+		}
+		_, exp, _ := config.codec().Decode(cookies[0].Name, cookies[0].Value)
+		return exp
+	}
+	now := time.Now().Truncate(time.Second)
+
+	// the cookie is not re-issued while most of the idle timeout remains
+	r, w := request(now, now.Add(time.Minute*9))
+	if _, err := config.getSessionUser(w, r); err != nil {
+		t.Fatalf("Expected User, got Error %s", err.Error())
+	}
+	if exp := expires(w); !exp.IsZero() {
+		t.Errorf("Expected cookie not re-issued, got expiration %v", exp)
+	}
+
+	// the cookie is re-issued near the idle timeout
+	r, w = request(now.Add(-time.Minute*20), now.Add(time.Minute*2))
+	config.getSessionUser(w, r)
+	if exp := expires(w); exp.Before(now.Add(time.Minute * 10)) {
+		t.Errorf("Expected cookie extended by the idle timeout, got expiration %v", exp)
+	}
+
+	// the cookie is never extended past the absolute timeout
+	r, w = request(now.Add(-time.Minute*55), now.Add(time.Minute*2))
+	config.getSessionUser(w, r)
+	if exp := expires(w); !exp.Equal(now.Add(time.Minute * 5)) {
+		t.Errorf("Expected cookie extended to the absolute timeout, got expiration %v", exp)
+	}
+
+	r, w = request(now.Add(-time.Minute*65), now.Add(time.Minute*2))
+	if _, err := config.getSessionUser(w, r); err != ErrSessionExpired {
+		t.Errorf("Expected ErrSessionExpired past the absolute timeout, got %v", err)
+	}
+}
+
+// Test the ability to keep an active session stored past the idle timeout,
+// and expire it once it is idle.
+func TestSessionStoreIdleTimeout(t *testing.T) {
+	t.Parallel()
+	config := NewConfig()
+	config.SessionStore = NewMemorySessionStore()
+	config.SessionIdleTimeout = time.Millisecond * 300
+
+	session, err := config.newSession(&user{id: "jdoe"}, time.Now())
+	if err != nil {
+		t.Fatalf("Expected session created, got Error %s", err.Error())
+	}
+
+	// requests are made for twice the idle timeout
+	for i := 0; i < 6; i++ {
+		time.Sleep(time.Millisecond * 100)
+		if _, _, err := config.getSession(session.Id); err != nil {
+			t.Fatalf("Expected active session after %d requests, got Error %s", i+1, err.Error())
+		}
+	}
+
+	time.Sleep(time.Millisecond * 400)
+	if _, _, err := config.getSession(session.Id); err != ErrSessionExpired {
+		t.Errorf("Expected ErrSessionExpired once idle, got %v", err)
+	}
+}
@@ -0,0 +1,59 @@
+package auth
+
+import (
+	"bufio"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// userFile holds the users loaded from a file, such as an htpasswd or an
+// htdigest file, and re-loads them when the file changes.
+type userFile struct {
+	sync.Mutex
+	users   map[string]string
+	modTime time.Time
+	size    int64
+}
+
+// reload loads the users if the file's modification time or size changed
+// since it was last loaded, and returns true if they were loaded. Each line
+// that is not blank or a comment is passed to parse, which returns the key
+// and value of the user. If the file can't be read, the previously loaded
+// users are kept.
+func (self *userFile) reload(path string, parse func(line string) (string, string, bool)) (bool, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return false, err
+	}
+	if self.users != nil && info.ModTime().Equal(self.modTime) && info.Size() == self.size {
+		return false, nil
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		return false, err
+	}
+	defer f.Close()
+
+	users := map[string]string{}
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if len(line) == 0 || strings.HasPrefix(line, "#") {
+			continue
+		}
+		if key, value, ok := parse(line); ok {
+			users[key] = value
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return false, err
+	}
+
+	self.users = users
+	self.modTime = info.ModTime()
+	self.size = info.Size()
+	return true, nil
+}